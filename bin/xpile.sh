@@ -6,27 +6,39 @@ BIN_DIR="$( cd "$( dirname "$0" )" && pwd )"
 GO_DIR="$BIN_DIR/../go"
 APP_NAME="pongishweb"
 
+# The vendored gopherjs can't compile a current Go standard library, gopherjs 1.21 compiles the Go 1.21 one.
+# Both are fetched by the go command the first time.
+GOPHERJS_VERSION="v1.21.0"
+GO_TOOLCHAIN="go1.21.13"
+GOPHERJS_DIR="$GO_DIR/bin/gopherjs-$GOPHERJS_VERSION"
+
 # If the gopherjs command is missing then create it.
-if [ ! -f "$GO_DIR/bin/gopherjs" ];
+if [ ! -f "$GOPHERJS_DIR/gopherjs" ];
 then
-   echo "building gopherjs"
-   cd "$GO_DIR"
-   gb build "github.com/gopherjs/gopherjs"
+   echo "building gopherjs $GOPHERJS_VERSION"
+   cd "$BIN_DIR"
+   GOTOOLCHAIN="$GO_TOOLCHAIN" GO111MODULE=on GOBIN="$GOPHERJS_DIR" \
+      go install "github.com/gopherjs/gopherjs@$GOPHERJS_VERSION"
+
+   # gopherjs has its own js and nosync packages, which have to come before the vendored ones on the GOPATH.
+   SRC=$(GOTOOLCHAIN="$GO_TOOLCHAIN" GO111MODULE=on go mod download -json "github.com/gopherjs/gopherjs@$GOPHERJS_VERSION" \
+      | sed -n 's/^[[:space:]]*"Dir": "\(.*\)",$/\1/p')
+   mkdir -p "$GOPHERJS_DIR/src/github.com/gopherjs/gopherjs"
+   cp -R "$SRC/js" "$SRC/nosync" "$GOPHERJS_DIR/src/github.com/gopherjs/gopherjs/"
+   chmod -R u+w "$GOPHERJS_DIR/src"
 fi
 
 # Transpile the gopherjs
 # gopherjs works like the regular go command and requires a GOPATH.
-rm -f $GO_DIR/bin/*.js
-rm -f $GO_DIR/bin/*.js.map
-export GOPATH="$GO_DIR:$GO_DIR/vendor"
-# This will create bin/ $APP_NAME.js and $APP_NAME.js.map.
-# "$GO_DIR/bin/gopherjs" -m install "github.com/snyderep/$APP_NAME"
-"$GO_DIR/bin/gopherjs" install "github.com/snyderep/$APP_NAME"
-unset GOPATH
-
-# Copy the transpiled js to the static dir
 GOLANG_JS="$BIN_DIR/../static/golangjs"
-rm -f "$GOLANG_JS/*.js"
-rm -f "$GOLANG_JS/*.js.map"
-cp -f "$GO_DIR/bin/"*.js "$BIN_DIR/../static/golangjs"
-cp -f "$GO_DIR/bin/"*.js.map "$BIN_DIR/../static/golangjs"
+export GOROOT="$(cd "$BIN_DIR" && GOTOOLCHAIN="$GO_TOOLCHAIN" go env GOROOT)"
+export GOPATH="$GOPHERJS_DIR:$GO_DIR:$GO_DIR/vendor"
+export GO111MODULE=off
+# This will create $APP_NAME.js and $APP_NAME.js.map in the static dir.
+"$GOPHERJS_DIR/gopherjs" build "github.com/snyderep/$APP_NAME" -o "$GOLANG_JS/$APP_NAME.js"
+unset GOROOT GOPATH GO111MODULE
+
+# gopherjs leaves its package cache in the vendor dir
+rm -rf "$GO_DIR/vendor/pkg"
+
+echo "bump the ?v= of $APP_NAME.js in templates/layouts/base.tmpl so browsers load the new client"
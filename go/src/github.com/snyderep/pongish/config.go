@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/snyderep/pongish/server"
 	"gopkg.in/gcfg.v1"
 )
 
@@ -12,14 +13,81 @@ type Settings struct {
 		StaticRoot    string
 		TemplateRoot  string
 		WsCheckOrigin bool
+		// AllowedOrigin may be repeated, see server.NewPongishHandlerProvider.
+		AllowedOrigin   []string
+		TLSCert         string
+		TLSKey          string
+		RedirectAddress string
+		// RecordRoot is where match recordings are kept, matches aren't recorded when it's empty.
+		RecordRoot string
+		// PlayerStore is the file players' ratings and stats are kept in, players aren't rated when it's empty.
+		PlayerStore string
+		// AchievementRoot is where achievements are loaded from, see server.LoadAchievements.
+		AchievementRoot string
+		// BoardRoot is where board layouts are loaded from, see server.LoadBoards.
+		BoardRoot string
+		// Board may be repeated, the public court is played on each in turn a week at a time.
+		Board []string
 	}
 	Client struct {
 		WebsocketGameEndpoint string
 	}
+	Limits      server.Limits
+	Session     server.SessionSettings
+	MultiBall   server.MultiBallSettings
+	PowerUps    server.PowerUpSettings
+	Pauses      server.PauseSettings
+	Rematch     server.RematchSettings
+	Matchmaking server.MatchmakingSettings
+	Rotation    server.RotationSettings
+	Export      server.ExportSettings
 }
 
 func loadSettings(settingsFile string) (Settings, error) {
 	s := Settings{}
+
+	// defaults, overridden by anything in the settings file
+	s.Limits = server.Limits{
+		MaxConnsPerIP:      0,
+		MaxConnsPerSession: 4,
+		MessagesPerSecond:  20,
+		MessageBurst:       40,
+		MaxViolations:      5,
+		MaxRoomsPerIP:      64,
+		MaxRoomsPerSession: 4,
+	}
+	s.Session = server.SessionSettings{
+		MaxAge:   86400 * 30,
+		HttpOnly: true,
+		SameSite: "lax",
+	}
+	s.MultiBall = server.MultiBallSettings{
+		MaxBalls:    3,
+		SpawnPeriod: 10,
+	}
+	s.PowerUps = server.PowerUpSettings{
+		Period:   8,
+		Lifetime: 10,
+		Duration: 10,
+	}
+	s.Pauses = server.PauseSettings{
+		MaxPauses: 2,
+		Duration:  60,
+		Countdown: 3,
+	}
+	s.Rematch = server.RematchSettings{
+		Timeout: 15,
+	}
+	s.Matchmaking = server.MatchmakingSettings{
+		Policy: "fifo",
+		Band:   100,
+		Widen:  10,
+	}
+	s.Rotation = server.RotationSettings{
+		Policy:      "king",
+		WinnerStays: 3,
+	}
+
 	err := gcfg.ReadFileInto(&s, settingsFile)
 
 	return s, err
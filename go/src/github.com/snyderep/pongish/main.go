@@ -17,20 +17,108 @@ func run(c *cli.Context) {
 		log.Fatal(err)
 	}
 
+	if settings.Server.RecordRoot != "" {
+		if err := server.EnableRecording(settings.Server.RecordRoot); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if settings.Server.PlayerStore != "" {
+		if err := server.EnablePlayerStore(settings.Server.PlayerStore); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if settings.Server.AchievementRoot != "" {
+		if err := server.LoadAchievements(settings.Server.AchievementRoot); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if settings.Server.BoardRoot != "" {
+		if err := server.LoadBoards(settings.Server.BoardRoot); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := server.RotateBoards(settings.Server.Board); err != nil {
+		log.Fatal(err)
+	}
+
+	server.ConfigureMultiBall(settings.MultiBall)
+	server.ConfigurePowerUps(settings.PowerUps)
+	server.ConfigurePauses(settings.Pauses)
+	server.ConfigureRematches(settings.Rematch)
+	if err := server.ConfigureMatchmaking(settings.Matchmaking); err != nil {
+		log.Fatal(err)
+	}
+	if err := server.ConfigureRotation(settings.Rotation); err != nil {
+		log.Fatal(err)
+	}
+	server.ConfigureExport(settings.Export)
+
+	sessionStore, err := server.NewSessionStore(settings.Session)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	s := &server.Server{
 		Address: settings.Server.Address,
 		Provider: server.NewPongishHandlerProvider(
 			server.NewNormalTemplateRenderer(settings.Server.TemplateRoot),
 			settings.Client.WebsocketGameEndpoint,
-			settings.Server.WsCheckOrigin),
-		StaticPrefix: settings.Server.StaticPrefix,
-		StaticRoot:   settings.Server.StaticRoot}
+			settings.Server.WsCheckOrigin,
+			settings.Server.AllowedOrigin,
+			settings.Limits,
+			sessionStore),
+		StaticPrefix:    settings.Server.StaticPrefix,
+		StaticRoot:      settings.Server.StaticRoot,
+		TLSCert:         settings.Server.TLSCert,
+		TLSKey:          settings.Server.TLSKey,
+		RedirectAddress: settings.Server.RedirectAddress}
 
 	if err := s.Listen(); err != nil {
 		log.Fatal(err)
 	}
 }
 
+// export writes an export of the server's matches or players to stdout, or to the file given by --out.
+func export(c *cli.Context) {
+	settings, err := loadSettings(c.GlobalString(flagConfig))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts, err := server.NewExportOptions(c.Args().First(), c.String("format"), c.String("from"), c.String("until"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if settings.Server.RecordRoot != "" {
+		if err := server.EnableRecording(settings.Server.RecordRoot); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if settings.Server.PlayerStore != "" {
+		if err := server.EnablePlayerStore(settings.Server.PlayerStore); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	out := os.Stdout
+	if name := c.String("out"); name != "" {
+		if out, err = os.Create(name); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := server.Export(out, opts); err != nil {
+		log.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
 func main() {
 	dir, err := filepath.Abs(filepath.Dir(os.Args[0]))
 	if err != nil {
@@ -47,5 +135,19 @@ func main() {
 		},
 	}
 	app.Action = run
+	app.Commands = []cli.Command{
+		{
+			Name:      "export",
+			Usage:     "export completed matches, rallies, hits or player stats as CSV or JSON",
+			ArgsUsage: "matches|rallies|hits|players",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "format", Value: "csv", Usage: "csv or json"},
+				cli.StringFlag{Name: "from", Usage: "only matches started from this date (2006-01-02) or RFC 3339 time"},
+				cli.StringFlag{Name: "until", Usage: "only matches started before this date or RFC 3339 time"},
+				cli.StringFlag{Name: "out", Usage: "file to write to instead of stdout"},
+			},
+			Action: export,
+		},
+	}
 	app.Run(os.Args)
 }
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// achievementT is something a player can achieve in a match, earning them a badge. Achievements are defined in
+// JSON files rather than code, so that new ones, like seasonal ones, can be added by dropping in a file.
+type achievementT struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// From and Until make the achievement seasonal, only matches started between them count. Dates are
+	// 2006-01-02, either can be left out.
+	From  string     `json:"from,omitempty"`
+	Until string     `json:"until,omitempty"`
+	When  conditionT `json:"when"`
+
+	from, until time.Time
+}
+
+// conditionT is what a match, and the player's record after it, must meet for the player to earn an
+// achievement. Every condition that is set has to be met.
+type conditionT struct {
+	Won  bool   `json:"won,omitempty"`  // won the match
+	Mode string `json:"mode,omitempty"` // the match was played in this mode
+	// a rally in the match lasted at least this many hits
+	Rally int `json:"rally,omitempty"`
+	// the player hit the ball at least this many times in the match
+	Hits int `json:"hits,omitempty"`
+	// won the match after losing at least this many in a row against the same opponents, matches are a single
+	// point so this is across matches rather than coming back within one
+	Revenge int `json:"revenge,omitempty"`
+	// won the match against opponents rated at least this much higher
+	Upset int `json:"upset,omitempty"`
+	// the player has played at least this many matches
+	Played int `json:"played,omitempty"`
+	// the player has won at least this many matches
+	Wins int `json:"wins,omitempty"`
+}
+
+// badgeT is an achievement a player has earned.
+type badgeT struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Match       string `json:"match"`
+	T           int64  `json:"t"` // unix seconds
+}
+
+// achievements are the achievements players can earn, loaded by LoadAchievements.
+var achievements []*achievementT
+
+// LoadAchievements loads the achievements defined in the .json files in root. Each file holds a list of
+// achievements, ids must be unique across every file.
+func LoadAchievements(root string) error {
+	files, err := filepath.Glob(filepath.Join(root, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	var loaded []*achievementT
+	ids := make(map[string]bool)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		// conditions this version doesn't know about are errors, rather than left out of the achievement
+		var list []*achievementT
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&list); err != nil {
+			return fmt.Errorf("server: achievements %s: %s", file, err)
+		}
+		for _, a := range list {
+			if err := a.validate(); err != nil {
+				return fmt.Errorf("server: achievements %s: %s", file, err)
+			}
+			if ids[a.ID] {
+				return fmt.Errorf("server: achievements %s: duplicate id %s", file, a.ID)
+			}
+			ids[a.ID] = true
+			loaded = append(loaded, a)
+		}
+	}
+
+	achievements = loaded
+	log.Printf("loaded %d achievements from %s\n", len(achievements), root)
+
+	return nil
+}
+
+func (a *achievementT) validate() error {
+	if a.ID == "" || a.Name == "" {
+		return fmt.Errorf("achievement without an id or name")
+	}
+	if a.When == (conditionT{}) {
+		return fmt.Errorf("%s: no conditions", a.ID)
+	}
+
+	var err error
+	if a.From != "" {
+		if a.from, err = time.Parse("2006-01-02", a.From); err != nil {
+			return fmt.Errorf("%s: %s", a.ID, err)
+		}
+	}
+	if a.Until != "" {
+		if a.until, err = time.Parse("2006-01-02", a.Until); err != nil {
+			return fmt.Errorf("%s: %s", a.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// award gives the player the badges they've earned in the match, rec has already been updated with the match.
+// It returns the new badges.
+func award(rec *playerRecordT, p resultPlayerT, r matchResultT) []badgeT {
+	held := make(map[string]bool)
+	for _, b := range rec.Badges {
+		held[b.ID] = true
+	}
+
+	var earned []badgeT
+	for _, a := range achievements {
+		if held[a.ID] || !a.inSeason(r.start) || !a.When.met(rec, p, r) {
+			continue
+		}
+		b := badgeT{ID: a.ID, Name: a.Name, Description: a.Description, Match: r.match, T: r.start.Unix()}
+		rec.Badges = append(rec.Badges, b)
+		earned = append(earned, b)
+	}
+
+	return earned
+}
+
+// inSeason returns true if a match started at start counts towards the achievement.
+func (a *achievementT) inSeason(start time.Time) bool {
+	if !a.from.IsZero() && start.Before(a.from) {
+		return false
+	}
+	if !a.until.IsZero() && !start.Before(a.until) {
+		return false
+	}
+	return true
+}
+
+func (w conditionT) met(rec *playerRecordT, p resultPlayerT, r matchResultT) bool {
+	if w.Won && !p.won {
+		return false
+	}
+	if w.Mode != "" && w.Mode != r.mode {
+		return false
+	}
+	if w.Rally > 0 && longestRally(r.rallies) < w.Rally {
+		return false
+	}
+	if w.Hits > 0 && p.stats.Hits < w.Hits {
+		return false
+	}
+	if w.Revenge > 0 && !(p.won && lostInARow(rec.Recent) >= w.Revenge) {
+		return false
+	}
+	if w.Upset > 0 && !(p.won && opponentRating(p, r)-p.rating >= float64(w.Upset)) {
+		return false
+	}
+	if w.Played > 0 && rec.Stats.Played < w.Played {
+		return false
+	}
+	if w.Wins > 0 && rec.Stats.Wins < w.Wins {
+		return false
+	}
+	return true
+}
+
+// lostInARow returns the number of matches before the last one that were lost in a row against the last one's
+// opponents.
+func lostInARow(recent []matchSummaryT) int {
+	if len(recent) == 0 {
+		return 0
+	}
+	last := sortedIDs(recent[len(recent)-1].Opponents)
+
+	n := 0
+	for i := len(recent) - 2; i >= 0; i-- {
+		if recent[i].Won || sortedIDs(recent[i].Opponents) != last {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+func sortedIDs(ids []string) string {
+	sorted := append([]string(nil), ids...)
+	sort.Strings(sorted)
+	return fmt.Sprint(sorted)
+}
+
+// opponentRating returns the average rating of the player's opponents before the match.
+func opponentRating(p resultPlayerT, r matchResultT) float64 {
+	var ratings []float64
+	for _, o := range r.players {
+		if o.won != p.won {
+			ratings = append(ratings, o.rating)
+		}
+	}
+	if len(ratings) == 0 {
+		return p.rating
+	}
+	return average(ratings)
+}
+
+func longestRally(rallies []int) int {
+	longest := 0
+	for _, hits := range rallies {
+		if hits > longest {
+			longest = hits
+		}
+	}
+	return longest
+}
+
+// announce tells a player about a badge they've just earned. It isn't worth waiting for a busy connection.
+func (p *player) announce(b badgeT) {
+	select {
+	case p.send <- fmt.Sprintf("K,%s,%s", b.ID, b.Name):
+	default:
+	}
+}
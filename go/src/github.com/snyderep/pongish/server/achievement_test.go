@@ -0,0 +1,60 @@
+package server
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestConditionRevenge(t *testing.T) {
+	lost := func(opponents ...string) matchSummaryT { return matchSummaryT{Opponents: opponents} }
+	won := func(opponents ...string) matchSummaryT { return matchSummaryT{Won: true, Opponents: opponents} }
+
+	tests := []struct {
+		name   string
+		won    bool
+		recent []matchSummaryT // the last one is the match just played
+		want   bool
+	}{
+		{name: "won after three losses", won: true, recent: []matchSummaryT{lost("a"), lost("a"), lost("a"), won("a")},
+			want: true},
+		{name: "won after two losses", won: true, recent: []matchSummaryT{won("a"), lost("a"), lost("a"), won("a")}},
+		{name: "losses to someone else", won: true, recent: []matchSummaryT{lost("a"), lost("b"), lost("a"), won("a")}},
+		{name: "doubles partners in any order", won: true,
+			recent: []matchSummaryT{lost("a", "b"), lost("b", "a"), lost("a", "b"), won("b", "a")}, want: true},
+		{name: "lost again", recent: []matchSummaryT{lost("a"), lost("a"), lost("a"), lost("a")}},
+	}
+
+	when := conditionT{Revenge: 3}
+	for _, tt := range tests {
+		rec := &playerRecordT{Recent: tt.recent}
+		if got := when.met(rec, resultPlayerT{won: tt.won}, matchResultT{}); got != tt.want {
+			t.Errorf("%s: met = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadAchievementsRejectsUnknownConditions(t *testing.T) {
+	defer func(loaded []*achievementT) { achievements = loaded }(achievements)
+
+	tests := []struct {
+		name    string
+		json    string
+		wantErr bool
+	}{
+		{name: "known", json: `[{"id": "revenge-3", "name": "Revenge", "when": {"revenge": 3}}]`},
+		{name: "unknown", json: `[{"id": "comeback-3", "name": "Comeback", "when": {"won": true, "comeback": 3}}]`,
+			wantErr: true},
+		{name: "no conditions", json: `[{"id": "nothing", "name": "Nothing", "when": {}}]`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		root := t.TempDir()
+		if err := ioutil.WriteFile(filepath.Join(root, "test.json"), []byte(tt.json), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := LoadAchievements(root); (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error %t", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+// Every ball put in play on a court has an id, carried in the serve and hand-off messages about it, so that any
+// number of balls can be in play at once. A player keeps track of the balls on their screen, and of any that
+// they've sent over the net that are waiting for the court to pass them on.
+
+// giveBall puts a ball on the player's screen.
+func (p *player) giveBall(id int) {
+	p.ballLock.Lock()
+	defer p.ballLock.Unlock()
+
+	p.balls[id] = ""
+}
+
+// sendOverNet keeps the net exchange message for a ball on the player's screen until the court passes it on.
+// A ball that isn't on the player's screen, because a teammate has already sent it over the net, is ignored.
+func (p *player) sendOverNet(id int, msg string) {
+	p.ballLock.Lock()
+	defer p.ballLock.Unlock()
+
+	if _, ok := p.balls[id]; ok {
+		p.balls[id] = msg
+	}
+}
+
+// netExchanges returns the net exchange messages of the player's balls that have been sent over the net, by id.
+func (p *player) netExchanges() map[int]string {
+	p.ballLock.Lock()
+	defer p.ballLock.Unlock()
+
+	exchanges := make(map[int]string)
+	for id, msg := range p.balls {
+		if msg != "" {
+			exchanges[id] = msg
+		}
+	}
+	return exchanges
+}
+
+// dropBall takes a ball off the player's screen.
+func (p *player) dropBall(id int) {
+	p.ballLock.Lock()
+	defer p.ballLock.Unlock()
+
+	delete(p.balls, id)
+}
+
+// dropBalls takes every ball off the player's screen.
+func (p *player) dropBalls() {
+	p.ballLock.Lock()
+	defer p.ballLock.Unlock()
+
+	p.balls = make(map[int]string)
+}
+
+// ballIDs returns the ids of the balls on the player's screen.
+func (p *player) ballIDs() []int {
+	p.ballLock.Lock()
+	defer p.ballLock.Unlock()
+
+	var ids []int
+	for id := range p.balls {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// ballsInPlay returns the number of different balls on the court's seats and segments.
+func (c *courtT) ballsInPlay() int {
+	ids := make(map[int]bool)
+	for _, p := range c.seats {
+		if p == nil {
+			continue
+		}
+		for _, id := range p.ballIDs() {
+			ids[id] = true
+		}
+	}
+	for _, d := range c.segments {
+		for _, id := range d.ballIDs() {
+			ids[id] = true
+		}
+	}
+	return len(ids)
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// obstacle kinds
+const (
+	obstacleBlock   = "block"   // a rectangle, X and Y are its top left corner
+	obstacleBumper  = "bumper"  // a circle of radius R that knocks the ball away, X and Y are its centre
+	obstacleBarrier = "barrier" // a rectangle moving up and down between MinY and MaxY by Speed each frame
+)
+
+const (
+	// size of one player's half of the court, the same as the board canvas on the screen page
+	halfCourtWidth  = 1300
+	halfCourtHeight = 1000
+	paddleHeight    = 150
+)
+
+// boardT is a court layout, the obstacles on every player's half of the court. Boards are defined as seen on
+// the LEFT half, the RIGHT half is a mirror image so that both players face the same layout.
+type boardT struct {
+	Name      string      `json:"name"`
+	Obstacles []obstacleT `json:"obstacles"`
+	// Goal narrows the end of each half, nil for the whole end. The rest of the end wall returns the ball.
+	Goal *goalT `json:"goal,omitempty"`
+}
+
+type obstacleT struct {
+	Kind  string `json:"kind"`
+	X     int    `json:"x"`
+	Y     int    `json:"y"`
+	W     int    `json:"w,omitempty"`
+	H     int    `json:"h,omitempty"`
+	R     int    `json:"r,omitempty"`
+	Speed int    `json:"speed,omitempty"`
+	MinY  int    `json:"minY,omitempty"`
+	MaxY  int    `json:"maxY,omitempty"`
+}
+
+// goalT is the part of the end of a half, between Top and Bottom, that the ball has to get past a paddle into.
+type goalT struct {
+	Top    int `json:"top"`
+	Bottom int `json:"bottom"`
+}
+
+// boards are the available boards by name, loaded by LoadBoards.
+var boards = make(map[string]*boardT)
+
+// LoadBoards loads every board defined in a .json file in root. A board is named after its file unless it has
+// a name of its own.
+func LoadBoards(root string) error {
+	files, err := filepath.Glob(filepath.Join(root, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	loaded := make(map[string]*boardT)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		b := &boardT{}
+		if err := json.Unmarshal(data, b); err != nil {
+			return fmt.Errorf("server: board %s: %s", file, err)
+		}
+		if b.Name == "" {
+			b.Name = strings.TrimSuffix(filepath.Base(file), ".json")
+		}
+		if err := b.validate(); err != nil {
+			return fmt.Errorf("server: board %s: %s", file, err)
+		}
+
+		loaded[strings.ToLower(b.Name)] = b
+	}
+
+	boards = loaded
+	log.Printf("loaded %d boards from %s\n", len(boards), root)
+
+	return nil
+}
+
+// RotateBoards sets the boards the public court is played on, a different one each week in turn.
+// No boards leaves the court empty.
+func RotateBoards(names []string) error {
+	for _, name := range names {
+		if _, err := findBoard(name); err != nil {
+			return fmt.Errorf("%s: %s", err, name)
+		}
+	}
+
+	court.lock.Lock()
+	defer court.lock.Unlock()
+
+	court.boards = names
+
+	return nil
+}
+
+// boardName returns the name of the board the court is played on this week, empty for an empty court.
+func (c *courtT) boardName() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if b := weeklyBoard(c.boards, c.clock.Now()); b != nil {
+		return b.Name
+	}
+	return ""
+}
+
+// findBoard returns the named board, nil for an empty name.
+func findBoard(name string) (*boardT, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	b, ok := boards[strings.ToLower(name)]
+	if !ok {
+		return nil, ErrUnknownBoard
+	}
+
+	return b, nil
+}
+
+// boardNames returns the names of the available boards in order.
+func boardNames() []string {
+	var names []string
+	for _, b := range boards {
+		names = append(names, b.Name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// weeklyBoard returns the board of the week from a rotation, nil if there are none.
+func weeklyBoard(rotation []string, now time.Time) *boardT {
+	if len(rotation) == 0 {
+		return nil
+	}
+
+	year, week := now.ISOWeek()
+	b, err := findBoard(rotation[(year*53+week)%len(rotation)])
+	if err != nil {
+		log.Printf("not using board: %s\n", err)
+	}
+
+	return b
+}
+
+func (b *boardT) validate() error {
+	for i, o := range b.Obstacles {
+		if o.X < 0 || o.X > halfCourtWidth || o.Y < 0 || o.Y > halfCourtHeight {
+			return fmt.Errorf("obstacle %d is off the court", i)
+		}
+
+		switch o.Kind {
+		case obstacleBlock:
+			if o.W <= 0 || o.H <= 0 {
+				return fmt.Errorf("block %d needs a width and height", i)
+			}
+		case obstacleBumper:
+			if o.R <= 0 {
+				return fmt.Errorf("bumper %d needs a radius", i)
+			}
+		case obstacleBarrier:
+			if o.W <= 0 || o.H <= 0 {
+				return fmt.Errorf("barrier %d needs a width and height", i)
+			}
+			if o.Speed <= 0 || o.MinY < 0 || o.MaxY <= o.MinY || o.MaxY+o.H > halfCourtHeight {
+				return fmt.Errorf("barrier %d needs a speed and to move between minY and maxY on the court", i)
+			}
+		default:
+			return fmt.Errorf("obstacle %d is an unknown kind %q", i, o.Kind)
+		}
+	}
+
+	if b.Goal != nil && (b.Goal.Top < 0 || b.Goal.Bottom > halfCourtHeight || b.Goal.Bottom-b.Goal.Top < paddleHeight) {
+		return fmt.Errorf("the goal must be on the court and at least as high as a paddle")
+	}
+
+	return nil
+}
+
+// sendBoardMsg tells a player the board they're playing on.
+func (p *player) sendBoardMsg(b *boardT) {
+	data := []byte("{}")
+	if b != nil {
+		var err error
+		if data, err = json.Marshal(b); err != nil {
+			log.Printf("error encoding board %s: %s\n", b.Name, err)
+			return
+		}
+	}
+
+	p.queue("G," + string(data))
+}
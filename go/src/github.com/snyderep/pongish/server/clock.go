@@ -0,0 +1,30 @@
+package server
+
+import (
+	"math/rand"
+	"time"
+)
+
+// clock is the source of time for courts, wait lists and players so that they can be driven by something
+// other than the wall clock.
+type clock interface {
+	Now() time.Time
+	// After waits for the duration to elapse and then sends the current time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the real, wall clock time.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// newRand returns a random source seeded from the wall clock.
+func newRand() *rand.Rand {
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
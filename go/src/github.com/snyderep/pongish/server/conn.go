@@ -0,0 +1,20 @@
+package server
+
+import (
+	"net"
+	"time"
+)
+
+// conn is the part of *websocket.Conn that players use, so that players can be given something other
+// than a real websocket.
+type conn interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetReadLimit(limit int64)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
+	RemoteAddr() net.Addr
+	Close() error
+}
@@ -20,3 +20,54 @@ var ErrTooManyWaiting = errors.New("server: too many waiting")
 
 // ErrMustBeWaitingState is returned when a player is required to be in waiting state but is not.
 var ErrMustBeWaitingState = errors.New("server: player must be in waiting state")
+
+// ErrUnknownRoom is returned when a private room code doesn't match any open room.
+var ErrUnknownRoom = errors.New("server: unknown room")
+
+// ErrTooManyRooms is returned when no more private rooms can be created.
+var ErrTooManyRooms = errors.New("server: too many rooms")
+
+// ErrTooManyRoomsCreated is returned when a remote IP or session already has too many open private rooms.
+var ErrTooManyRoomsCreated = errors.New("server: too many rooms created")
+
+// ErrTooManyConnections is returned when a remote IP or session already has too many game connections.
+var ErrTooManyConnections = errors.New("server: too many connections")
+
+// ErrUnknownMatch is returned when there is no recording of a match.
+var ErrUnknownMatch = errors.New("server: unknown match")
+
+// ErrNotRecording is returned when asked for match recordings while matches aren't being recorded.
+var ErrNotRecording = errors.New("server: matches are not being recorded")
+
+// ErrNotStoringPlayers is returned when asked for players' records while they aren't being kept.
+var ErrNotStoringPlayers = errors.New("server: players are not being rated")
+
+// ErrUnknownExport is returned when asked to export something that can't be exported.
+var ErrUnknownExport = errors.New("server: unknown export, it's one of matches, rallies, hits or players")
+
+// ErrUnknownExportFormat is returned when asked to export in a format other than CSV or JSON.
+var ErrUnknownExportFormat = errors.New("server: unknown export format, it's csv or json")
+
+// ErrUnknownMode is returned when asked for a court mode that doesn't exist.
+var ErrUnknownMode = errors.New("server: unknown mode")
+
+// ErrUnknownMatchmaker is returned when asked for a matchmaker that doesn't exist.
+var ErrUnknownMatchmaker = errors.New("server: unknown matchmaker")
+
+// ErrUnknownRotation is returned when asked for a rotation that doesn't exist.
+var ErrUnknownRotation = errors.New("server: unknown rotation")
+
+// ErrUnknownBoard is returned when asked for a board that hasn't been loaded.
+var ErrUnknownBoard = errors.New("server: unknown board")
+
+// ErrNoSegments is returned when a display joins a court whose mode has no display segments.
+var ErrNoSegments = errors.New("server: court has no display segments")
+
+// ErrTooManySegments is returned when a court already has as many display segments as its mode allows.
+var ErrTooManySegments = errors.New("server: too many display segments")
+
+// ErrUnknownPlayer is returned when there is no record of a player.
+var ErrUnknownPlayer = errors.New("server: unknown player")
+
+// ErrUnknownTheme is returned when asked for a theme the web client doesn't have.
+var ErrUnknownTheme = errors.New("server: unknown theme")
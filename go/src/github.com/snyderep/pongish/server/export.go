@@ -0,0 +1,536 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// export kinds
+const (
+	exportMatches = "matches" // a row for each match
+	exportRallies = "rallies" // a row for each rally, from a ball being served until it gets past a paddle
+	exportHits    = "hits"    // a row for each ball hit by a paddle
+	exportPlayers = "players" // a row for each player, their rating and stats
+)
+
+// export formats
+const (
+	exportCSV  = "csv"
+	exportJSON = "json"
+)
+
+// ExportSettings are who can download exports over http. The export command can always export everything.
+type ExportSettings struct {
+	// Token is sent by whoever downloads an export, as a bearer token in the Authorization header. /export is
+	// turned off when it's empty.
+	Token string
+}
+
+var exportSettings ExportSettings
+
+// ConfigureExport sets who can download exports from now on.
+func ConfigureExport(settings ExportSettings) {
+	exportSettings = settings
+}
+
+// exportAllowed returns true if the request can download an export.
+func exportAllowed(r *http.Request) bool {
+	if exportSettings.Token == "" {
+		return false
+	}
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), []byte(exportSettings.Token)) == 1
+}
+
+// ExportOptions are what to export. Everything comes from match recordings, apart from players' ratings and
+// badges which come from the player store.
+type ExportOptions struct {
+	Kind   string
+	Format string
+	// From and Until limit the export to matches started from From until, but not including, Until. Players'
+	// stats and badges are only from those matches. Either can be zero for no limit.
+	From  time.Time
+	Until time.Time
+}
+
+// NewExportOptions checks and parses export options given as text. Times are either dates, 2006-01-02, or
+// RFC 3339 times, either can be empty.
+func NewExportOptions(kind string, format string, from string, until string) (ExportOptions, error) {
+	opts := ExportOptions{Kind: strings.ToLower(kind), Format: strings.ToLower(format)}
+	if opts.Format == "" {
+		opts.Format = exportCSV
+	}
+
+	switch opts.Kind {
+	case exportMatches, exportRallies, exportHits, exportPlayers:
+	default:
+		return opts, ErrUnknownExport
+	}
+	if opts.Format != exportCSV && opts.Format != exportJSON {
+		return opts, ErrUnknownExportFormat
+	}
+
+	var err error
+	if opts.From, err = parseExportTime(from); err != nil {
+		return opts, err
+	}
+	if opts.Until, err = parseExportTime(until); err != nil {
+		return opts, err
+	}
+
+	return opts, nil
+}
+
+func parseExportTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("server: %q is neither a date nor an RFC 3339 time", s)
+	}
+	return t, nil
+}
+
+// contentType returns the content type of the export.
+func (opts ExportOptions) contentType() string {
+	if opts.Format == exportJSON {
+		return "application/json"
+	}
+	return "text/csv"
+}
+
+// within returns true if t is in the export's time range.
+func (opts ExportOptions) within(t time.Time) bool {
+	if !opts.From.IsZero() && t.Before(opts.From) {
+		return false
+	}
+	if !opts.Until.IsZero() && !t.Before(opts.Until) {
+		return false
+	}
+	return true
+}
+
+// available returns an error if what's being exported isn't being kept.
+func (opts ExportOptions) available() error {
+	if recordStore == nil {
+		return ErrNotRecording
+	}
+	if opts.Kind == exportPlayers && playerStore == nil {
+		return ErrNotStoringPlayers
+	}
+	return nil
+}
+
+// Export writes what opts asks for to w.
+func Export(w io.Writer, opts ExportOptions) error {
+	if err := opts.available(); err != nil {
+		return err
+	}
+
+	columns := exportColumns[opts.Kind]
+
+	var out exportWriterT
+	if opts.Format == exportJSON {
+		out = newJSONExportWriter(w, columns)
+	} else {
+		out = newCSVExportWriter(w, columns)
+	}
+	if err := out.start(); err != nil {
+		return err
+	}
+
+	var err error
+	if opts.Kind == exportPlayers {
+		err = exportPlayerRows(out, opts)
+	} else {
+		err = exportMatchRows(out, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	return out.finish()
+}
+
+// exportColumns are the columns of each kind of export.
+var exportColumns = map[string][]string{
+	exportMatches: {"match", "start", "mode", "board", "seats", "players", "duration_ms", "end", "lost_seats",
+		"serves", "hits", "rallies", "longest_rally"},
+	exportRallies: {"match", "ball", "served_ms", "ended_ms", "serve_seat", "serve_y", "serve_angle", "serve_speed",
+		"hits", "lost_seat"},
+	exportHits: {"match", "t_ms", "seat", "side", "lane", "ball", "x", "y", "angle", "speed"},
+	exportPlayers: {"player", "rating", "rd", "provisional", "played", "wins", "hits", "misses", "serves_received",
+		"rallies", "rally_hits", "longest_rally", "played_ms", "badges"},
+}
+
+// matches returns the ids of the recorded matches started in the export's time range, oldest first.
+func (s *recordStoreT) matches(opts ExportOptions) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(s.root, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+
+	var matchIDs []string
+	for _, file := range files {
+		matchID := strings.TrimSuffix(filepath.Base(file), ".jsonl")
+		var id xid.ID
+		if err := id.UnmarshalText([]byte(matchID)); err != nil {
+			continue
+		}
+		// match ids are xids, which start with the time they were made
+		if opts.within(id.Time()) {
+			matchIDs = append(matchIDs, matchID)
+		}
+	}
+	// and sort in the order they were made
+	sort.Strings(matchIDs)
+
+	return matchIDs, nil
+}
+
+func exportMatchRows(out exportWriterT, opts ExportOptions) error {
+	matchIDs, err := recordStore.matches(opts)
+	if err != nil {
+		return err
+	}
+
+	for _, matchID := range matchIDs {
+		events, err := recordStore.load(matchID)
+		if err != nil {
+			return err
+		}
+		if !completed(events) {
+			continue
+		}
+
+		switch opts.Kind {
+		case exportMatches:
+			err = exportMatch(out, matchID, events)
+		case exportRallies:
+			err = exportRallyRows(out, matchID, events)
+		case exportHits:
+			err = exportHitRows(out, matchID, events)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// completed returns true if a match's events are of a completed match, not one still being played or cut off by
+// the server stopping.
+func completed(events []matchEvent) bool {
+	return len(events) >= 2 && events[0].Kind == eventStart && events[len(events)-1].Kind == eventEnd
+}
+
+// rallyT is a ball's rally, from its serve until it got past a paddle or the match ended.
+type rallyT struct {
+	serve matchEvent
+	end   *matchEvent // nil if the match ended with the ball still in play
+	hits  int
+}
+
+// rallies returns the rallies in a match's events, in the order they were served.
+func rallies(events []matchEvent) []*rallyT {
+	var all []*rallyT
+	byBall := make(map[int]*rallyT)
+	for _, e := range events {
+		switch e.Kind {
+		case eventServe:
+			// a ball served to a whole team has a serve event for each teammate
+			if byBall[e.Ball] == nil {
+				r := &rallyT{serve: e}
+				byBall[e.Ball] = r
+				all = append(all, r)
+			}
+		case eventHit:
+			if r := byBall[e.Ball]; r != nil {
+				r.hits++
+			}
+		case eventLost:
+			if r := byBall[e.Ball]; r != nil && r.end == nil {
+				end := e
+				r.end = &end
+			}
+		}
+	}
+	return all
+}
+
+func exportMatch(out exportWriterT, matchID string, events []matchEvent) error {
+	start := events[0]
+	last := events[len(events)-1]
+
+	board := ""
+	if start.Board != nil {
+		board = start.Board.Name
+	}
+
+	var lost []string
+	hits := 0
+	for _, e := range events {
+		switch e.Kind {
+		case eventHit:
+			hits++
+		case eventLost:
+			lost = append(lost, fmt.Sprint(e.Seat))
+		}
+	}
+
+	served := rallies(events)
+	ended, longest := 0, 0
+	for _, r := range served {
+		if r.end != nil {
+			ended++
+			if r.hits > longest {
+				longest = r.hits
+			}
+		}
+	}
+
+	return out.row([]interface{}{matchID, start.Start, start.Mode, board, len(start.Seats),
+		strings.Join(start.Players, " "), last.T, last.Reason, strings.Join(lost, " "), len(served), hits, ended, longest})
+}
+
+func exportRallyRows(out exportWriterT, matchID string, events []matchEvent) error {
+	for _, r := range rallies(events) {
+		endedMillis, lostSeat := interface{}(nil), interface{}(nil)
+		if r.end != nil {
+			endedMillis, lostSeat = r.end.T, r.end.Seat
+		}
+		err := out.row([]interface{}{matchID, r.serve.Ball, r.serve.T, endedMillis, r.serve.Seat, r.serve.Y,
+			r.serve.Angle, r.serve.Speed, r.hits, lostSeat})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportHitRows(out exportWriterT, matchID string, events []matchEvent) error {
+	for _, e := range events {
+		if e.Kind != eventHit {
+			continue
+		}
+		if err := out.row([]interface{}{matchID, e.T, e.Seat, e.Side, e.Lane, e.Ball, e.X, e.Y, e.Angle,
+			e.Speed}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchPlayerStats returns what each player with an id got from a completed match, counted the way recordStats
+// counts it. The match is over for a player once their team lets a ball past or they leave, and for everyone
+// still in once a loss or a leaver ends it, so players still in when the court closed get nothing from it.
+// Nobody gets anything from a match a player was in twice.
+func matchPlayerStats(events []matchEvent) map[string]playerStatsT {
+	start, end := events[0], events[len(events)-1]
+
+	in := make(map[string]bool)
+	for _, id := range start.Players {
+		if id != "" && in[id] {
+			return nil
+		}
+		in[id] = true
+	}
+
+	// a match of its own to tally the events the same as when they were played
+	m := &matchT{seatStats: make(map[int]*seatStatsT), rallyHits: make(map[int]int)}
+
+	stats := make(map[string]playerStatsT)
+	out := make(map[int]bool)
+	keep := func(seat int, won bool, t int64) {
+		out[seat] = true
+		if seat >= len(start.Players) || start.Players[seat] == "" {
+			return
+		}
+
+		s := playerStatsT{Played: 1, Rallies: len(m.rallies), LongestRally: longestRally(m.rallies), PlayedMillis: t}
+		if won {
+			s.Wins = 1
+		}
+		if seatStats := m.seatStats[seat]; seatStats != nil {
+			s.Hits, s.Misses, s.ServesReceived = seatStats.Hits, seatStats.Misses, seatStats.ServesReceived
+		}
+		for _, hits := range m.rallies {
+			s.RallyHits += hits
+		}
+		stats[start.Players[seat]] = s
+	}
+
+	for _, e := range events {
+		m.tally(e)
+		if (e.Kind != eventLost && e.Kind != eventLeft) || e.Seat >= len(start.Seats) {
+			continue
+		}
+		// the seat's whole team loses with it
+		for i, seat := range start.Seats {
+			if !out[i] && seat.Team == start.Seats[e.Seat].Team {
+				keep(i, false, e.T)
+			}
+		}
+	}
+	if end.Reason == endLost || end.Reason == endLeft {
+		for i := range start.Seats {
+			if !out[i] {
+				keep(i, true, end.T)
+			}
+		}
+	}
+
+	return stats
+}
+
+func exportPlayerRows(out exportWriterT, opts ExportOptions) error {
+	matchIDs, err := recordStore.matches(opts)
+	if err != nil {
+		return err
+	}
+
+	totals := make(map[string]*playerStatsT)
+	exported := make(map[string]bool)
+	for _, matchID := range matchIDs {
+		events, err := recordStore.load(matchID)
+		if err != nil {
+			return err
+		}
+		if !completed(events) {
+			continue
+		}
+		exported[matchID] = true
+
+		for id, s := range matchPlayerStats(events) {
+			if totals[id] == nil {
+				totals[id] = &playerStatsT{}
+			}
+			totals[id].add(s)
+		}
+	}
+
+	var ids []string
+	for id := range totals {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		// ratings are as they are now, there's no telling what they were at the end of the range
+		rating, rd, provisional := interface{}(nil), interface{}(nil), interface{}(nil)
+		badges := 0
+		if r, ok := playerStore.get(id); ok {
+			rating, rd, provisional = r.Rating, r.RD, r.provisional()
+			for _, b := range r.Badges {
+				if exported[b.Match] {
+					badges++
+				}
+			}
+		}
+
+		s := totals[id]
+		err := out.row([]interface{}{id, rating, rd, provisional, s.Played, s.Wins, s.Hits, s.Misses,
+			s.ServesReceived, s.Rallies, s.RallyHits, s.LongestRally, s.PlayedMillis, badges})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportWriterT writes the rows of an export in a format.
+type exportWriterT interface {
+	start() error
+	// row writes a row, its values in the same order as the columns. A nil value is an empty cell.
+	row(values []interface{}) error
+	finish() error
+}
+
+// csvExportWriterT writes a header line of the column names, then a line for each row.
+type csvExportWriterT struct {
+	w       *csv.Writer
+	columns []string
+}
+
+func newCSVExportWriter(w io.Writer, columns []string) *csvExportWriterT {
+	return &csvExportWriterT{w: csv.NewWriter(w), columns: columns}
+}
+
+func (cw *csvExportWriterT) start() error {
+	return cw.w.Write(cw.columns)
+}
+
+func (cw *csvExportWriterT) row(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		if v != nil {
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return cw.w.Write(record)
+}
+
+func (cw *csvExportWriterT) finish() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// jsonExportWriterT writes a JSON array with an object for each row, keyed by the column names.
+type jsonExportWriterT struct {
+	w       io.Writer
+	columns []string
+	rows    int
+}
+
+func newJSONExportWriter(w io.Writer, columns []string) *jsonExportWriterT {
+	return &jsonExportWriterT{w: w, columns: columns}
+}
+
+func (jw *jsonExportWriterT) start() error {
+	_, err := io.WriteString(jw.w, "[")
+	return err
+}
+
+func (jw *jsonExportWriterT) row(values []interface{}) error {
+	obj := make(map[string]interface{}, len(values))
+	for i, v := range values {
+		obj[jw.columns[i]] = v
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	sep := ",\n"
+	if jw.rows == 0 {
+		sep = "\n"
+	}
+	jw.rows++
+
+	_, err = io.WriteString(jw.w, sep+string(data))
+	return err
+}
+
+func (jw *jsonExportWriterT) finish() error {
+	_, err := io.WriteString(jw.w, "\n]\n")
+	return err
+}
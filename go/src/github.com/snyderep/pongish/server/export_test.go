@@ -0,0 +1,212 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+func TestMatchPlayerStats(t *testing.T) {
+	classic := classicMode{}.layout()
+	doubles := doublesMode{}.layout()
+	ring := (&ringMode{size: 4}).layout()
+
+	start := func(seats []seatT, players ...string) matchEvent {
+		return matchEvent{Kind: eventStart, Seats: seats, Players: players}
+	}
+	serve := func(t int64, seat int, ball int) matchEvent {
+		return matchEvent{T: t, Kind: eventServe, Seat: seat, Ball: ball}
+	}
+	hit := func(t int64, seat int, ball int) matchEvent {
+		return matchEvent{T: t, Kind: eventHit, Seat: seat, Ball: ball}
+	}
+	lost := func(t int64, seat int, ball int) matchEvent {
+		return matchEvent{T: t, Kind: eventLost, Seat: seat, Ball: ball}
+	}
+	left := func(t int64, seat int) matchEvent { return matchEvent{T: t, Kind: eventLeft, Seat: seat} }
+	end := func(t int64, reason string) matchEvent { return matchEvent{T: t, Kind: eventEnd, Reason: reason} }
+
+	tests := []struct {
+		name   string
+		events []matchEvent
+		want   map[string]playerStatsT
+	}{
+		{
+			name: "classic",
+			events: []matchEvent{start(classic, "a", "b"), serve(0, 0, 1), hit(100, 0, 1), hit(200, 1, 1),
+				lost(300, 0, 1), end(300, endLost)},
+			want: map[string]playerStatsT{
+				"a": {Played: 1, Hits: 1, Misses: 1, ServesReceived: 1, Rallies: 1, RallyHits: 2, LongestRally: 2,
+					PlayedMillis: 300},
+				"b": {Played: 1, Wins: 1, Hits: 1, Rallies: 1, RallyHits: 2, LongestRally: 2, PlayedMillis: 300},
+			},
+		},
+		{
+			name:   "players without ids",
+			events: []matchEvent{start(classic, "", "b"), serve(0, 0, 1), lost(100, 0, 1), end(100, endLost)},
+			want: map[string]playerStatsT{
+				"b": {Played: 1, Wins: 1, Rallies: 1, PlayedMillis: 100},
+			},
+		},
+		{
+			name: "doubles team loses together",
+			events: []matchEvent{start(doubles, "a", "b", "c", "d"), serve(0, 0, 1), serve(0, 1, 1),
+				lost(100, 1, 1), end(100, endLost)},
+			want: map[string]playerStatsT{
+				"a": {Played: 1, ServesReceived: 1, Rallies: 1, PlayedMillis: 100},
+				"b": {Played: 1, Misses: 1, ServesReceived: 1, Rallies: 1, PlayedMillis: 100},
+				"c": {Played: 1, Wins: 1, Rallies: 1, PlayedMillis: 100},
+				"d": {Played: 1, Wins: 1, Rallies: 1, PlayedMillis: 100},
+			},
+		},
+		{
+			name: "ring knock outs are over before the ring is",
+			events: []matchEvent{start(ring, "a", "b", "c", ""), serve(0, 0, 1), lost(100, 0, 1), serve(100, 1, 2),
+				hit(150, 1, 2), lost(200, 2, 2), end(200, endLost)},
+			want: map[string]playerStatsT{
+				"a": {Played: 1, Misses: 1, ServesReceived: 1, Rallies: 1, PlayedMillis: 100},
+				"b": {Played: 1, Wins: 1, Hits: 1, ServesReceived: 1, Rallies: 2, RallyHits: 1, LongestRally: 1,
+					PlayedMillis: 200},
+				"c": {Played: 1, Misses: 1, Rallies: 2, RallyHits: 1, LongestRally: 1, PlayedMillis: 200},
+			},
+		},
+		{
+			name:   "leaving loses",
+			events: []matchEvent{start(classic, "a", "b"), serve(0, 0, 1), left(100, 1), end(100, endLeft)},
+			want: map[string]playerStatsT{
+				"a": {Played: 1, Wins: 1, ServesReceived: 1, PlayedMillis: 100},
+				"b": {Played: 1, PlayedMillis: 100},
+			},
+		},
+		{
+			name: "court closed",
+			events: []matchEvent{start(ring, "a", "b", "c", ""), serve(0, 0, 1), lost(100, 0, 1),
+				end(200, endClosed)},
+			want: map[string]playerStatsT{
+				"a": {Played: 1, Misses: 1, ServesReceived: 1, Rallies: 1, PlayedMillis: 100},
+			},
+		},
+		{
+			name:   "player in twice",
+			events: []matchEvent{start(classic, "a", "a"), serve(0, 0, 1), lost(100, 0, 1), end(100, endLost)},
+		},
+	}
+
+	for _, tt := range tests {
+		got := matchPlayerStats(tt.events)
+		if len(got) == 0 && len(tt.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// matchIDAt returns a new match id made at t.
+func matchIDAt(t time.Time) string {
+	id := xid.New()
+	binary.BigEndian.PutUint32(id[:4], uint32(t.Unix()))
+	return id.String()
+}
+
+// writeRecording writes the events of a match as if it had been recorded.
+func writeRecording(t *testing.T, matchID string, events []matchEvent) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(recordStore.root, matchID+".jsonl"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportPlayers(t *testing.T) {
+	if err := EnableRecording(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { recordStore = nil })
+	useTestPlayerStore(t)
+
+	classic := classicMode{}.layout()
+	day := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	before, during := matchIDAt(day.Add(-48*time.Hour)), matchIDAt(day)
+
+	writeRecording(t, before, []matchEvent{{Kind: eventStart, Seats: classic, Players: []string{"a", "b"}},
+		{T: 1000, Kind: eventHit, Seat: 0, Ball: 1}, {T: 2000, Kind: eventLost, Seat: 1, Ball: 1},
+		{T: 2000, Kind: eventEnd, Reason: endLost}})
+	writeRecording(t, during, []matchEvent{{Kind: eventStart, Seats: classic, Players: []string{"a", "c"}},
+		{T: 500, Kind: eventServe, Seat: 0, Ball: 1}, {T: 700, Kind: eventLost, Seat: 0, Ball: 1},
+		{T: 700, Kind: eventEnd, Reason: endLost}})
+	// still being played, or cut off by the server stopping
+	writeRecording(t, matchIDAt(day.Add(time.Hour)), []matchEvent{
+		{Kind: eventStart, Seats: classic, Players: []string{"a", "c"}}, {T: 500, Kind: eventLost, Seat: 0, Ball: 1}})
+
+	// lifetime stats and badges from both matches, only those from the one in range are exported
+	err := playerStore.update([]string{"a"}, func(r *playerRecordT) {
+		r.Stats = playerStatsT{Played: 2, Wins: 1, Hits: 1, Misses: 1, ServesReceived: 1, PlayedMillis: 2700}
+		r.Badges = []badgeT{{ID: "first-win", Match: before}, {ID: "first-loss", Match: during}}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := NewExportOptions(exportPlayers, exportCSV, "2026-03-02", "2026-03-03")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := Export(&out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join([]string{
+		"player,rating,rd,provisional,played,wins,hits,misses,serves_received,rallies,rally_hits,longest_rally," +
+			"played_ms,badges",
+		"a,1500,350,true,1,0,0,1,1,1,0,0,700,1",
+		"c,,,,1,1,0,0,0,1,0,0,700,0",
+	}, "\n") + "\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestExportAllowed(t *testing.T) {
+	defer ConfigureExport(exportSettings)
+
+	tests := []struct {
+		name  string
+		token string
+		auth  string
+		want  bool
+	}{
+		{name: "turned off", auth: "Bearer "},
+		{name: "no token sent", token: "s3cret"},
+		{name: "wrong token", token: "s3cret", auth: "Bearer guess"},
+		{name: "not a bearer token", token: "s3cret", auth: "Basic s3cret"},
+		{name: "token", token: "s3cret", auth: "Bearer s3cret", want: true},
+	}
+
+	for _, tt := range tests {
+		ConfigureExport(ExportSettings{Token: tt.token})
+		r := httptest.NewRequest("GET", "/export/players", nil)
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		if got := exportAllowed(r); got != tt.want {
+			t.Errorf("%s: exportAllowed = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// errFakeConnClosed is returned by a fakeConn once it has been closed.
+var errFakeConnClosed = errors.New("server: fake connection closed")
+
+// fakeConn is an in-memory conn for driving players without a network. Messages queued with deliver are
+// read by the player, messages the player writes are kept and returned by sent.
+type fakeConn struct {
+	addr     fakeAddr
+	incoming chan []byte
+	closed   chan struct{}
+	lock     sync.Mutex
+	written  []string
+	stalled  bool // writes block until the connection is closed, like a peer that stopped reading
+	once     sync.Once
+}
+
+func newFakeConn(addr string) *fakeConn {
+	return &fakeConn{addr: fakeAddr(addr), incoming: make(chan []byte, 64), closed: make(chan struct{})}
+}
+
+// deliver queues a text message for the player to read.
+func (c *fakeConn) deliver(msg string) {
+	c.incoming <- []byte(msg)
+}
+
+// sent returns the text messages the player has written so far.
+func (c *fakeConn) sent() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return append([]string(nil), c.written...)
+}
+
+// stall makes writes block, as they would to a peer that has stopped reading, until the connection is closed.
+func (c *fakeConn) stall() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.stalled = true
+}
+
+func (c *fakeConn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *fakeConn) ReadMessage() (int, []byte, error) {
+	select {
+	case msg := <-c.incoming:
+		return websocket.TextMessage, msg, nil
+	case <-c.closed:
+		return 0, nil, errFakeConnClosed
+	}
+}
+
+func (c *fakeConn) WriteMessage(messageType int, data []byte) error {
+	if c.isClosed() {
+		return errFakeConnClosed
+	}
+	c.lock.Lock()
+	stalled := c.stalled
+	c.lock.Unlock()
+	if stalled {
+		<-c.closed
+		return errFakeConnClosed
+	}
+	if messageType == websocket.TextMessage {
+		c.lock.Lock()
+		c.written = append(c.written, string(data))
+		c.lock.Unlock()
+	}
+	return nil
+}
+
+func (c *fakeConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	if c.isClosed() {
+		return errFakeConnClosed
+	}
+	return nil
+}
+
+func (c *fakeConn) SetReadLimit(limit int64)                    {}
+func (c *fakeConn) SetReadDeadline(t time.Time) error           { return nil }
+func (c *fakeConn) SetWriteDeadline(t time.Time) error          { return nil }
+func (c *fakeConn) SetPongHandler(h func(appData string) error) {}
+func (c *fakeConn) RemoteAddr() net.Addr                        { return c.addr }
+
+func (c *fakeConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+type fakeAddr string
+
+func (a fakeAddr) Network() string { return "fake" }
+func (a fakeAddr) String() string  { return string(a) }
+
+// fakeClock is a clock that only moves when advanced.
+type fakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+
+	return ch
+}
+
+// advance moves the clock forward, firing anything waiting for a time that has now been reached.
+func (c *fakeClock) advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = c.now.Add(d)
+
+	waiting := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiting = append(waiting, w)
+		} else {
+			w.ch <- c.now
+		}
+	}
+	c.waiters = waiting
+}
@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -15,14 +16,13 @@ import (
 
 type stateT uint8
 
-var rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
-
 // player states
 const (
 	waiting stateT = iota
 	playing
 	dead
 	lost
+	displaying // a display only segment of the court, see segment.go
 )
 
 type sideT string
@@ -39,152 +39,584 @@ const (
 	writeWait        = time.Duration(2) * time.Second
 )
 
+// the most messages queued for a connection, a connection further behind than that is dropped
+const sendBuffer = 32
+
 type courtT struct {
-	waiters     *waitListT // waiting to play
-	leftPlayer  *player
-	rightPlayer *player
+	waiters    *waitListT  // waiting to play
+	matchmaker matchmakerT // picks who on the wait list plays next, see matchmaking.go
+	rotation   rotationT   // picks who else leaves the court with the losers, see rotation.go
+	mode       modeT
+	layout     []seatT
+	lock       sync.Mutex // guards seats
+	seats      []*player  // indexed like layout, nil when a seat is empty
+	segments   []*player  // display only screens between the LEFT and RIGHT sides, in order from LEFT to RIGHT
+	done       chan struct{}
+	closeOnce  sync.Once
+	matchLock  sync.Mutex
+	match      *matchT // nil unless the court is ready to play
+	clock      clock
+	rnd        *rand.Rand
+	nextBall   int         // the id of the next ball served
+	powerUps   *powerUpsT  // nil unless the court has power-ups, see powerup.go
+	boards     []string    // the boards the court is played on, a different one each week, see board.go
+	board      *boardT     // the board of the current match, nil for an empty court
+	lastServe  time.Time   // when the last ball was served
+	theme      string      // the colour theme screens show the court in by default, empty for the client's default
+	pause      *pauseT     // nil unless the match is paused, see pause.go
+	pausesUsed map[int]int // pauses each seat has taken this match
+	rematch    *rematchT   // nil unless a rematch has been offered, see rematch.go
+}
+
+func newCourt(maxWaiting int, mode modeT) *courtT {
+	return newCourtWith(maxWaiting, mode, systemClock{}, newRand())
 }
 
-func newCourt() *courtT {
-	waitList := newWaitListT(256)
-	court := &courtT{waiters: waitList}
+// newCourtWith creates a court that takes its time from clk and serves using rnd. The court updates every
+// boardStatePeriod according to clk.
+func newCourtWith(maxWaiting int, mode modeT, clk clock, rnd *rand.Rand) *courtT {
+	waitList := newWaitListT(maxWaiting, clk)
+	layout := mode.layout()
+	court := &courtT{
+		waiters:    waitList,
+		matchmaker: fifoMatchmaker{},
+		rotation:   kingRotation{},
+		mode:       mode,
+		layout:     layout,
+		seats:      make([]*player, len(layout)),
+		done:       make(chan struct{}),
+		clock:      clk,
+		rnd:        rnd,
+	}
 
 	go func() {
 		for {
-			<-time.NewTimer(boardStatePeriod).C
-
-			// move any losers to the waiting list
-			if court.sendLoserToWaitList(court.leftPlayer) {
-				court.leftPlayer = nil
-			}
-			if court.sendLoserToWaitList(court.rightPlayer) {
-				court.rightPlayer = nil
-			}
-
-			if err := court.doNetExchange(); err != nil {
-				panic(err)
+			select {
+			case <-court.done:
+				return
+			case <-clk.After(boardStatePeriod):
 			}
 
-			// ensure we have 2 players
-			court.ensurePlayers()
-
-			// ensure there's a ball on the court
-			court.ensureBall()
+			court.step()
 		}
 	}()
 
 	return court
 }
 
-func (c *courtT) doNetExchange() error {
-	if c.leftPlayer != nil && c.rightPlayer != nil {
-		var neMsg string
-		if c.leftPlayer.ball && c.leftPlayer.netExchange != "" {
-			neMsg = c.leftPlayer.netExchange
-		} else if c.rightPlayer.ball && c.rightPlayer.netExchange != "" {
-			neMsg = c.rightPlayer.netExchange
+// step moves the court on by one board state period.
+func (c *courtT) step() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// move any losers to the waiting list, unless they're offered a rematch
+	c.sendLosersToWaitList()
+	c.doRematch()
+
+	c.doPauses()
+	if !c.paused() {
+		c.doPowerUps()
+	}
+
+	if err := c.doNetExchange(); err != nil {
+		panic(err)
+	}
+
+	// ensure the seats are filled
+	c.ensurePlayers()
+	c.ensureSegments()
+	c.ensureMatch()
+
+	// ensure there's a ball on the court, and any more the mode spawns
+	if !c.paused() {
+		c.ensureBall()
+	}
+}
+
+// seated returns the number of filled seats.
+func (c *courtT) seated() int {
+	n := 0
+	for _, p := range c.seats {
+		if p != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// team returns the seats in a team.
+func (c *courtT) team(team int) []int {
+	var seats []int
+	for i, seat := range c.layout {
+		if seat.Team == team {
+			seats = append(seats, i)
 		}
+	}
+	return seats
+}
 
-		if neMsg != "" {
-			parts := strings.Split(neMsg, ",")
+// teammates returns the players seated in the same team as p, not including p.
+func (c *courtT) teammates(p *player) []*player {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 
-			yPos, err := strconv.ParseInt(parts[1], 0, 32)
-			if err != nil {
-				return err
+	var mates []*player
+	for _, i := range c.team(c.layout[p.seat].Team) {
+		if mate := c.seats[i]; mate != nil && mate != p {
+			mates = append(mates, mate)
+		}
+	}
+	return mates
+}
+
+// empty returns true if nobody is on the court or waiting to play on it.
+func (c *courtT) empty() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.seated() == 0 && len(c.segments) == 0 && c.waiters.Len() == 0
+}
+
+// close stops the court and its wait list and disconnects anyone still on it.
+func (c *courtT) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.endMatch(endClosed)
+		c.waiters.close()
+
+		c.lock.Lock()
+		defer c.lock.Unlock()
+
+		for _, p := range c.seats {
+			if p != nil {
+				p.wsConn.Close()
 			}
-			angle, err := strconv.ParseInt(parts[2], 0, 32)
+		}
+		for _, d := range c.segments {
+			d.wsConn.Close()
+		}
+	})
+}
+
+// doNetExchange passes balls on from any seat that has sent them over the net.
+func (c *courtT) doNetExchange() error {
+	if !c.mode.ready(c) {
+		c.dropSegmentBalls()
+		return nil
+	}
+
+	if err := c.doSegmentExchange(); err != nil {
+		return err
+	}
+
+	for from, p := range c.seats {
+		if p == nil {
+			continue
+		}
+
+		for id, msg := range p.netExchanges() {
+			yPos, angle, speed, err := parseNetExchange(msg)
 			if err != nil {
 				return err
 			}
-			speed, err := strconv.ParseInt(parts[3], 0, 32)
-			if err != nil {
-				return err
+
+			// the ball has left the whole team, teammates may also be about to send it over the net
+			for _, i := range c.team(c.layout[from].Team) {
+				if mate := c.seats[i]; mate != nil {
+					mate.dropBall(id)
+				}
 			}
 
-			if c.leftPlayer.ball {
-				c.rightPlayer.sendBallInMsg(int(yPos), int(angle), int(speed))
-				c.leftPlayer.ball = false
-				c.leftPlayer.netExchange = ""
-			} else if c.rightPlayer.ball {
-				c.leftPlayer.sendBallInMsg(int(yPos), int(angle), int(speed))
-				c.rightPlayer.ball = false
-				c.rightPlayer.netExchange = ""
+			speed, split := c.handOffEffects(id, speed)
+			c.passBall(from, id, yPos, angle, speed)
+			if split >= 0 {
+				// the new ball heads off the other way up or down the court
+				c.passBall(from, split, yPos, (360-angle)%360, speed)
 			}
 		}
 	}
+
 	return nil
 }
 
-func (c *courtT) ensurePlayers() {
-	// TODO: dry this up, this is getting ugly
-	if c.leftPlayer == nil || c.leftPlayer.state == dead {
-		if c.leftPlayer != nil && c.leftPlayer.state == dead {
-			c.leftPlayer.wsConn.Close()
+// passBall sends a ball that seat from sent over the net on to where the mode says it goes.
+func (c *courtT) passBall(from int, id int, yPos int, angle int, speed int) {
+	if len(c.segments) > 0 {
+		// the ball crosses the segments first, starting with the one next to this side
+		next := 0
+		if c.layout[from].Side == right {
+			next = len(c.segments) - 1
 		}
-		c.leftPlayer = c.waiters.Take()
-		if c.leftPlayer != nil {
-			log.Printf("taking left player from wait list. addr: %s\n", c.leftPlayer.addr())
-			c.leftPlayer.play(left)
+		c.segments[next].sendBallInMsg(id, yPos, angle, speed)
+		return
+	}
+
+	to, inAngle := c.mode.pass(c, from, angle)
+	c.sendBall(eventHandoff, to, id, yPos, inAngle, speed)
+}
+
+// parseNetExchange parses the position, angle and speed out of a net exchange message that has already been
+// validated by validNetExchange.
+func parseNetExchange(msg string) (int, int, int, error) {
+	parts := strings.Split(msg, ",")
+
+	yPos, err := strconv.ParseInt(parts[1], 0, 32)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	angle, err := strconv.ParseInt(parts[2], 0, 32)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	speed, err := strconv.ParseInt(parts[3], 0, 32)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	return int(yPos), int(angle), int(speed), nil
+}
+
+// sendBall puts a ball in play on each of the seats.
+func (c *courtT) sendBall(kind string, seats []int, id int, yPos int, angle int, speed int) {
+	for _, i := range seats {
+		p := c.seats[i]
+		if p == nil {
+			continue
 		}
+		c.record(matchEvent{Kind: kind, Seat: i, Side: p.side, Lane: c.layout[i].Lane, Ball: id, Y: yPos, Angle: angle,
+			Speed: speed})
+		p.sendBallInMsg(id, yPos, angle, speed)
+	}
+}
+
+func (c *courtT) ensurePlayers() {
+	if c.walkedOut() {
+		// nobody is left to have won or lost
+		c.endMatch(endWalkedOut)
 	}
-	if c.rightPlayer == nil || c.rightPlayer.state == dead {
-		if c.rightPlayer != nil && c.rightPlayer.state == dead {
-			c.rightPlayer.wsConn.Close()
+
+	for i, p := range c.seats {
+		if p != nil && p.getState() == dead {
+			log.Printf("%s player at seat %d left. addr: %s\n", p.side, i, p.addr())
+			if c.inMatch() {
+				// leaving loses the match, the same as letting the ball past would
+				c.record(matchEvent{Kind: eventLeft, Seat: i, Side: p.side, Lane: c.layout[i].Lane})
+				c.lose(i, endLeft)
+				continue
+			}
+			p.wsConn.Close()
+			c.seats[i] = nil
 		}
-		c.rightPlayer = c.waiters.Take()
-		if c.rightPlayer != nil {
-			log.Printf("taking right player from wait list. addr: %s\n", c.leftPlayer.addr())
-			c.rightPlayer.play(right)
+	}
+
+	// nobody else is seated while the last match's players decide on a rematch
+	if c.rematch != nil || !c.mode.refill(c) {
+		return
+	}
+
+	var empty []int
+	var seated []*player
+	for i, p := range c.seats {
+		if p == nil {
+			empty = append(empty, i)
+		} else {
+			seated = append(seated, p)
 		}
 	}
+	if len(empty) == 0 {
+		return
+	}
+
+	// rotations that schedule who plays whom pick from the wait list themselves
+	m := c.matchmaker
+	if scheduler, ok := c.rotation.(matchmakerT); ok {
+		m = scheduler
+	}
+
+	for j, p := range c.waiters.Take(m, seated, len(empty)) {
+		i := empty[j]
+		seat := c.layout[i]
+		log.Printf("taking %s player for seat %d from wait list by %s. addr: %s\n", seat.Side, i, m.name(), p.addr())
+		c.seats[i] = p
+		p.play(i, seat)
+	}
 }
 
+// ensureBall serves a ball if there are none in play. Modes that spawn more balls can have another served
+// while there are balls in play.
 func (c *courtT) ensureBall() {
-	if c.leftPlayer != nil && c.rightPlayer != nil && !c.leftPlayer.ball && !c.rightPlayer.ball {
-		yPos := rnd.Intn(800) + 100
-		angle := rnd.Intn(90) + 135
-		speed := rnd.Intn(4) + 2
-		// we'll always serve to the left player for now
-		log.Printf("serving ball to left player. add: %s\n", c.leftPlayer.addr())
-		c.leftPlayer.sendBallInMsg(yPos, angle, speed)
+	if !c.mode.ready(c) {
+		return
+	}
+
+	now := c.clock.Now()
+	if inPlay := c.ballsInPlay(); inPlay > 0 {
+		s, ok := c.mode.(spawnerT)
+		if !ok || !s.spawn(c, inPlay, now.Sub(c.lastServe)) {
+			return
+		}
+	}
+
+	to := c.mode.serve(c)
+	if len(to) == 0 {
+		return
+	}
+
+	id := c.nextBall
+	c.nextBall++
+	c.lastServe = now
+
+	yPos := c.rnd.Intn(800) + 100
+	angle := serveAngle(c.layout[to[0]].Side, c.rnd.Intn(90)+135)
+	speed := c.rnd.Intn(4) + 2
+	log.Printf("serving ball %d to %s seats %v\n", id, c.layout[to[0]].Side, to)
+	c.sendBall(eventServe, to, id, yPos, angle, speed)
+}
+
+// serveAngle turns an angle towards the left end of the court into one towards the end of side.
+func serveAngle(side sideT, angle int) int {
+	if side == right {
+		return mirrorAngle(angle)
+	}
+	return angle
+}
+
+// mirrorAngle reverses the horizontal direction of an angle in degrees.
+func mirrorAngle(angle int) int {
+	return ((540-angle)%360 + 360) % 360
+}
+
+// ensureMatch starts a match once the court is ready to play.
+func (c *courtT) ensureMatch() {
+	if !c.mode.ready(c) {
+		return
+	}
+
+	c.matchLock.Lock()
+	defer c.matchLock.Unlock()
+
+	if c.match == nil {
+		c.board = weeklyBoard(c.boards, c.clock.Now())
+		players := make([]string, len(c.seats))
+		for i, p := range c.seats {
+			if p != nil {
+				players[i] = p.id
+			}
+		}
+		c.match = newMatch(c.clock, c.mode.name(), c.layout, players, c.board)
+		c.pausesUsed = make(map[int]int)
+		c.cancelPause()
+		log.Printf("%s match %s started\n", c.mode.name(), c.match.id)
+
+		for _, p := range c.seats {
+			if p != nil {
+				p.sendBoardMsg(c.board)
+			}
+		}
+	}
+}
+
+// inMatch returns true if a match is being played.
+func (c *courtT) inMatch() bool {
+	c.matchLock.Lock()
+	defer c.matchLock.Unlock()
+
+	return c.match != nil
+}
+
+// endMatch finishes the current match, if there is one.
+func (c *courtT) endMatch(reason string) {
+	c.matchLock.Lock()
+	defer c.matchLock.Unlock()
+
+	if c.match != nil {
+		log.Printf("match %s ended: %s\n", c.match.id, reason)
+		c.match.finish(reason)
+		c.match = nil
+	}
+}
+
+// record adds an event to the current match, if there is one.
+func (c *courtT) record(e matchEvent) {
+	c.matchLock.Lock()
+	defer c.matchLock.Unlock()
+
+	if c.match != nil {
+		c.match.record(e)
+	}
+}
+
+// sendLosersToWaitList moves the seats the mode vacates for each loser to the back of the wait list.
+func (c *courtT) sendLosersToWaitList() {
+	for from, p := range c.seats {
+		if p == nil || p.getState() != lost {
+			continue
+		}
+
+		c.record(matchEvent{Kind: eventLost, Seat: from, Side: p.side, Lane: c.layout[from].Lane, Ball: p.lostBall})
+		c.lose(from, endLost)
+	}
+}
+
+// lose vacates the seats the mode says go with seat from's loss, and if that's the end of the match, rotates
+// whoever else the rotation sends off and ends the match for reason. Players who are still connected go to the
+// back of the wait list, or wait for a rematch when one is offered. Leaving, reason endLeft, loses the same way
+// but never gets a rematch.
+func (c *courtT) lose(from int, reason string) {
+	vacated, over := c.mode.lose(c, from)
+
+	// everyone on the court at the end of the match, for a rematch
+	var lineup []*player
+	rematch := over && reason == endLost && c.rematchAllowed()
+	if rematch {
+		lineup = append(lineup, c.seats...)
+	}
+	c.keepResult(vacated, over)
+	if over {
+		rotated := c.rotation.rotate(c, vacated)
+		for _, i := range rotated {
+			if p := c.seats[i]; p != nil && p.getState() != dead {
+				p.sendRotateMsg()
+			}
+		}
+		vacated = append(vacated, rotated...)
+	}
+
+	for _, i := range vacated {
+		if loser := c.seats[i]; loser != nil {
+			c.seats[i] = nil
+			loser.dropBalls()
+			loser.lastPlayed = c.clock.Now()
+			if !loser.setState(waiting) {
+				loser.wsConn.Close()
+				continue
+			}
+			if rematch {
+				continue
+			}
+			if err := c.waiters.Add(loser); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+
+	if over {
+		c.endMatch(reason)
+		if rematch {
+			c.offerRematch(lineup)
+		}
+	}
+}
+
+// walkedOut returns true if a match is being played and every player in it has left.
+func (c *courtT) walkedOut() bool {
+	if !c.inMatch() {
+		return false
+	}
+	for _, p := range c.seats {
+		if p != nil && p.getState() != dead {
+			return false
+		}
 	}
+	return true
 }
 
-func (c *courtT) sendLoserToWaitList(p *player) bool {
-	if p != nil && p.state == lost {
-		p.state = waiting
-		if err := c.waiters.Add(p); err != nil {
-			log.Println(err)
+// keepResult rates the players in the vacated seats as having lost to everyone still seated. Players knocked
+// out before the match is over, as in a ring, lose to those still in. Players who have left don't win, they're
+// dealt with as leavers. The match is added to the stats of the players it is over for.
+func (c *courtT) keepResult(vacated []int, over bool) {
+	c.matchLock.Lock()
+	defer c.matchLock.Unlock()
+
+	if c.match == nil {
+		return
+	}
+
+	lost := make(map[int]bool)
+	for _, i := range vacated {
+		lost[i] = true
+	}
+	var winners, losers []*player
+	inResult := make([]*player, len(c.seats))
+	for i, p := range c.seats {
+		if p == nil {
+			continue
+		}
+		if lost[i] {
+			losers = append(losers, p)
+		} else if p.getState() != dead {
+			winners = append(winners, p)
+		} else {
+			continue
 		}
-		return true
+		inResult[i] = p
+	}
+	if len(winners) == 0 {
+		return
 	}
 
-	return false
+	now := c.clock.Now()
+	id := c.match.id
+	result := c.match.result(c.mode.name(), now, inResult, lost, over)
+
+	// ratings and stats are saved to disk, which the court shouldn't wait for
+	saving.Add(1)
+	go func() {
+		defer saving.Done()
+		rateMatch(id, now, winners, losers)
+		recordStats(result)
+	}()
 }
 
-var court = newCourt()
+// court is the public court, anyone not joining a private room plays here.
+var court = newCourt(256, classicMode{})
 
 type waitListT struct {
 	lst     *list.List
 	lock    sync.RWMutex
 	maxSize int
+	done    chan struct{}
+	clock   clock
 }
 
-func newWaitListT(maxSize int) *waitListT {
-	pl := &waitListT{maxSize: maxSize, lst: list.New()}
+// newWaitListT creates a wait list that prunes dead players every second according to clk.
+func newWaitListT(maxSize int, clk clock) *waitListT {
+	pl := &waitListT{maxSize: maxSize, lst: list.New(), done: make(chan struct{}), clock: clk}
 
 	go func() {
-		pruneTicker := time.NewTicker(time.Second * 1)
-
 		for {
-			<-pruneTicker.C
-			pl.pruneDead()
+			select {
+			case <-pl.done:
+				return
+			case <-clk.After(time.Second * 1):
+				pl.pruneDead()
+			}
 		}
 	}()
 
 	return pl
 }
 
+// close stops pruning and disconnects everyone still waiting.
+func (pl *waitListT) close() {
+	close(pl.done)
+
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+
+	for e := pl.lst.Front(); e != nil; e = e.Next() {
+		e.Value.(*player).wsConn.Close()
+	}
+	pl.lst.Init()
+}
+
+func (pl *waitListT) Len() int {
+	pl.lock.RLock()
+	defer pl.lock.RUnlock()
+
+	return pl.lst.Len()
+}
+
 func (pl *waitListT) Add(w *player) error {
 	pl.lock.Lock()
 	defer pl.lock.Unlock()
@@ -192,28 +624,47 @@ func (pl *waitListT) Add(w *player) error {
 	if pl.lst.Len() >= pl.maxSize {
 		return ErrTooManyWaiting
 	}
-	if w.state != waiting {
+	if w.getState() != waiting {
 		return ErrMustBeWaitingState
 	}
 
+	w.waitingSince = pl.clock.Now()
 	pl.lst.PushBack(w)
 
 	return nil
 }
 
-func (pl *waitListT) Take() *player {
+// Take removes and returns up to n waiting players, chosen by m, to play the seated players. Dead players are
+// never chosen.
+func (pl *waitListT) Take(m matchmakerT, seated []*player, n int) []*player {
 	pl.lock.Lock()
 	defer pl.lock.Unlock()
 
-	element := pl.lst.Front()
-
-	if element == nil {
+	var waiting []*player
+	for e := pl.lst.Front(); e != nil; e = e.Next() {
+		if p := e.Value.(*player); p.getState() != dead {
+			waiting = append(waiting, p)
+		}
+	}
+	if len(waiting) == 0 {
 		return nil
 	}
 
-	player := pl.lst.Remove(element).(*player)
+	picked := m.pick(waiting, seated, n, pl.clock.Now())
 
-	return player
+	taken := make(map[*player]bool)
+	for _, p := range picked {
+		taken[p] = true
+	}
+	for e := pl.lst.Front(); e != nil; {
+		next := e.Next()
+		if taken[e.Value.(*player)] {
+			pl.lst.Remove(e)
+		}
+		e = next
+	}
+
+	return picked
 }
 
 func (pl *waitListT) pruneDead() {
@@ -225,7 +676,7 @@ func (pl *waitListT) pruneDead() {
 	for e := pl.lst.Front(); e != nil; e = e.Next() {
 		p := e.Value.(*player)
 		//log.Println(p)
-		if p.state == dead {
+		if p.getState() == dead {
 			if err := p.wsConn.Close(); err != nil {
 				log.Printf("error closing websocket connection for %s: %s\n", p.addr(), err)
 			}
@@ -235,39 +686,99 @@ func (pl *waitListT) pruneDead() {
 }
 
 type player struct {
-	ball        bool
-	netExchange string
-	state       stateT
-	start       time.Time
-	wsConn      *websocket.Conn
-	send        chan string
+	id              string         // from the player's session, empty if they don't have one
+	balls           map[int]string // see ball.go
+	ballLock        sync.Mutex     // guards balls
+	lostBall        int            // the ball that got past the player's paddle once they've lost
+	collected       map[int]int    // power-ups collected by the player's balls, see powerup.go, guarded by ballLock
+	requestLock     sync.Mutex     // guards the requests below
+	pauseRequested  bool           // see pause.go
+	resumeRequested bool
+	rematch         int       // the player's answer to a rematch offer, see rematch.go
+	waitingSince    time.Time // when the player joined the wait list, guarded by the wait list's lock
+	lastPlayed      time.Time // when the player last left a seat, zero if they haven't played
+	state           uint32    // a stateT, changed by the court and the connection pumps, see getState and setState
+	start           time.Time
+	wsConn          conn
+	send            chan string
+	limiter         *rateLimiterT
+	violations      int
+	maxViolations   int
+	release         func() // called once the connection is finished with
+	court           *courtT
+	seat            int
+	side            sideT
 }
 
-func addPlayer(wsConn *websocket.Conn) error {
-	now := time.Now()
+// addPlayer puts a new player on the court's wait list. id identifies the player between visits, empty if they
+// have no session.
+func addPlayer(c *courtT, wsConn conn, id string, limits Limits, release func()) error {
+	p := newPlayer(c, wsConn, id, waiting, limits, release)
 
-	p := &player{state: waiting, start: now, wsConn: wsConn, send: make(chan string, 8)}
+	if err := c.waiters.Add(p); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// newPlayer creates a player on the court in state and starts reading from and writing to its connection.
+func newPlayer(c *courtT, wsConn conn, id string, state stateT, limits Limits, release func()) *player {
+	p := &player{
+		id:            id,
+		balls:         make(map[int]string),
+		collected:     make(map[int]int),
+		start:         c.clock.Now(),
+		wsConn:        wsConn,
+		send:          make(chan string, sendBuffer),
+		limiter:       newRateLimiter(limits.MessagesPerSecond, limits.MessageBurst, c.clock),
+		maxViolations: limits.MaxViolations,
+		release:       release,
+		court:         c,
+	}
+	p.setState(state)
 
 	// start reading from the websocket connection
 	go p.readPump()
 	// start writing to the websocket connection
 	go p.writePump()
 
-	if err := court.waiters.Add(p); err != nil {
-		return err
-	}
-
-	return nil
+	return p
 }
 
-func (p *player) play(side sideT) {
+func (p *player) play(seat int, s seatT) {
 	// tell the client that it's playing
-	p.sendPlayMsg(side)
-	p.state = playing
+	p.sendPlayMsg(s)
+	p.seat = seat
+	p.side = s.Side
+	p.setState(playing)
+}
+
+// getState returns the player's state.
+func (p *player) getState() stateT {
+	return stateT(atomic.LoadUint32(&p.state))
+}
+
+// setState changes the player's state, returning false if the player is dead. Nobody comes back from being dead,
+// a connection pump may have found the connection finished while the court was moving the player on.
+func (p *player) setState(state stateT) bool {
+	for {
+		old := atomic.LoadUint32(&p.state)
+		if stateT(old) == dead {
+			return false
+		}
+		if atomic.CompareAndSwapUint32(&p.state, old, uint32(state)) {
+			return true
+		}
+	}
 }
 
 func (p *player) playing() bool {
-	return p.state == playing
+	return p.getState() == playing
+}
+
+func (p *player) displaying() bool {
+	return p.getState() == displaying
 }
 
 func (p *player) notPlaying() bool {
@@ -278,24 +789,49 @@ func (p *player) addr() string {
 	return p.wsConn.RemoteAddr().String()
 }
 
-func (p *player) sendPlayMsg(side sideT) {
-	p.send <- fmt.Sprintf("P,%s", string(side))
+// queue queues a message to be written to the player's connection. The court never waits on a connection, one
+// that has stopped taking messages, say its write pump has already given up, is left as if the player had.
+func (p *player) queue(msg string) {
+	select {
+	case p.send <- msg:
+	default:
+		if p.setState(dead) {
+			log.Printf("dropping %s, their connection isn't taking messages\n", p.addr())
+		}
+	}
+}
+
+func (p *player) sendPlayMsg(s seatT) {
+	p.queue(fmt.Sprintf("P,%s,%d,%d", string(s.Side), s.Lane, s.Lanes))
+}
+
+// sendPaddleMsg tells a teammate where another paddle on their half of the court is.
+func (p *player) sendPaddleMsg(lane int, yPos int, move int) {
+	p.queue(fmt.Sprintf("O,%d,%d,%d", lane, yPos, move))
 }
 
-func (p *player) sendBallInMsg(pos int, angle int, speed int) {
-	p.ball = true
-	p.send <- fmt.Sprintf("B,%d,%d,%d", pos, angle, speed)
+// sendBallSyncMsg tells a teammate where a ball is after another paddle on their half hit it.
+func (p *player) sendBallSyncMsg(id int, xPos int, yPos int, angle int, speed int) {
+	p.queue(fmt.Sprintf("S,%d,%d,%d,%d,%d", xPos, yPos, angle, speed, id))
+}
+
+func (p *player) sendBallInMsg(id int, pos int, angle int, speed int) {
+	p.giveBall(id)
+	p.queue(fmt.Sprintf("B,%d,%d,%d,%d", pos, angle, speed, id))
 }
 
 func (p *player) readPump() {
 	defer func() {
-		p.state = dead
+		p.setState(dead)
+		if p.release != nil {
+			p.release()
+		}
 	}()
 
 	p.wsConn.SetReadLimit(1024)
-	p.wsConn.SetReadDeadline(time.Now().Add(pongWait))
+	p.wsConn.SetReadDeadline(p.court.clock.Now().Add(pongWait))
 	p.wsConn.SetPongHandler(func(string) error {
-		p.wsConn.SetReadDeadline(time.Now().Add(pongWait))
+		p.wsConn.SetReadDeadline(p.court.clock.Now().Add(pongWait))
 		return nil
 	})
 
@@ -310,26 +846,136 @@ func (p *player) readPump() {
 
 		log.Printf("ws read from %v, msg type: %v, msg: %v\n", remoteAddr, msgType, msg)
 
+		if !p.limiter.allow() {
+			if p.violation("message rate exceeded") {
+				break
+			}
+			continue
+		}
+
 		msgS := string(msg)
 		parts := strings.Split(msgS, ",")
 
-		if parts[0] == "L" {
-			p.handleLostMsg()
+		if msgType != websocket.TextMessage {
+			if p.violation("unsupported message type") {
+				break
+			}
+		} else if parts[0] == "L" {
+			// a teammate's loss may already have taken this player off the court
+			if ints, ok := parseBallID(parts[1:], 0); !ok {
+				if p.violation("invalid lost message") {
+					break
+				}
+			} else if p.playing() {
+				p.handleLostMsg(ints[0])
+			}
 		} else if parts[0] == "N" {
 			log.Printf("net exchange msg: %s\n", msgS)
-			p.netExchange = msgS
+			if ints, ok := parseBallID(parts[1:], 3); !ok || !(p.playing() || p.displaying()) {
+				if p.violation("invalid net exchange message") {
+					break
+				}
+			} else {
+				p.sendOverNet(ints[3], msgS)
+			}
+		} else if parts[0] == "M" {
+			if ints, ok := parseInts(parts[1:], 2); ok && p.playing() {
+				p.handlePaddleMsg(ints[0], ints[1])
+			} else if p.violation("invalid paddle message") {
+				break
+			}
+		} else if parts[0] == "C" {
+			if ints, ok := parseInts(parts[1:], 2); ok && p.playing() {
+				p.collectPowerUp(ints[0], ints[1])
+			} else if p.violation("invalid power-up message") {
+				break
+			}
+		} else if parts[0] == "Z" || parts[0] == "R" {
+			if len(parts) != 1 || !p.playing() {
+				if p.violation("invalid pause message") {
+					break
+				}
+			} else if parts[0] == "Z" {
+				p.requestPause()
+			} else {
+				p.requestResume()
+			}
+		} else if parts[0] == "A" {
+			// losers answer from the wait list
+			if ints, ok := parseInts(parts[1:], 1); ok && (p.playing() || p.getState() == waiting) {
+				answer := rematchDeclined
+				if ints[0] == 1 {
+					answer = rematchAccepted
+				}
+				p.answerRematch(answer)
+			} else if p.violation("invalid rematch message") {
+				break
+			}
+		} else if parts[0] == "H" {
+			if ints, ok := parseBallID(parts[1:], 4); ok && p.playing() {
+				p.handleHitMsg(ints[4], ints[0], ints[1], ints[2], ints[3])
+			} else if p.violation("invalid hit message") {
+				break
+			}
 		} else {
 			log.Printf("unsupported message: %v\n", msg)
+			if p.violation("unsupported message") {
+				break
+			}
 		}
 	}
 }
 
+// violation records a protocol violation and returns true if the player has now committed too many
+// and has been disconnected.
+func (p *player) violation(reason string) bool {
+	p.violations++
+	log.Printf("protocol violation from %s (%d of %d allowed): %s\n", p.addr(), p.violations, p.maxViolations, reason)
+
+	if p.maxViolations <= 0 || p.violations <= p.maxViolations {
+		return false
+	}
+
+	log.Printf("disconnecting %s for protocol violations\n", p.addr())
+	p.wsConn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason), p.court.clock.Now().Add(writeWait))
+	p.wsConn.Close()
+
+	return true
+}
+
+// parseBallID parses n message fields as ints followed by a ball id, which is optional and 0 if missing.
+// The id is the last of the returned ints.
+func parseBallID(fields []string, n int) ([]int, bool) {
+	if len(fields) == n {
+		fields = append(fields, "0")
+	}
+	return parseInts(fields, n+1)
+}
+
+// parseInts parses exactly n message fields as ints.
+func parseInts(fields []string, n int) ([]int, bool) {
+	if len(fields) != n {
+		return nil, false
+	}
+
+	ints := make([]int, n)
+	for i, field := range fields {
+		v, err := strconv.ParseInt(field, 0, 32)
+		if err != nil {
+			return nil, false
+		}
+		ints[i] = int(v)
+	}
+
+	return ints, true
+}
+
 func (p *player) writePump() {
-	ticker := time.NewTicker(pingPeriod)
+	ping := p.court.clock.After(pingPeriod)
 
 	defer func() {
-		ticker.Stop()
-		p.state = dead
+		p.setState(dead)
 	}()
 
 	for {
@@ -344,24 +990,46 @@ func (p *player) writePump() {
 				p.write(websocket.CloseMessage, []byte{})
 				return
 			}
-		case <-ticker.C:
+		case <-ping:
 			if err := p.write(websocket.PingMessage, []byte{}); err != nil {
 				return
 			}
+			ping = p.court.clock.After(pingPeriod)
 		}
 	}
 }
 
 func (p *player) write(messageType int, message []byte) error {
-	p.wsConn.SetWriteDeadline(time.Now().Add(writeWait))
+	p.wsConn.SetWriteDeadline(p.court.clock.Now().Add(writeWait))
 	return p.wsConn.WriteMessage(messageType, message)
 }
 
-func (p *player) handleLostMsg() {
-	p.state = lost
-	p.ball = false
+// handlePaddleMsg records a paddle movement and shows it to any teammates.
+func (p *player) handlePaddleMsg(yPos int, move int) {
+	lane := p.court.layout[p.seat].Lane
+	p.court.record(matchEvent{Kind: eventPaddle, Seat: p.seat, Side: p.side, Lane: lane, Y: yPos, Move: move})
+
+	for _, mate := range p.court.teammates(p) {
+		mate.sendPaddleMsg(lane, yPos, move)
+	}
+}
+
+// handleHitMsg records a ball being hit and tells any teammates where it went.
+func (p *player) handleHitMsg(id int, xPos int, yPos int, angle int, speed int) {
+	p.court.record(matchEvent{Kind: eventHit, Seat: p.seat, Side: p.side, Lane: p.court.layout[p.seat].Lane,
+		Ball: id, X: xPos, Y: yPos, Angle: angle, Speed: speed})
+
+	for _, mate := range p.court.teammates(p) {
+		mate.sendBallSyncMsg(id, xPos, yPos, angle, speed)
+	}
+}
+
+func (p *player) handleLostMsg(id int) {
+	p.lostBall = id
+	p.setState(lost)
+	p.dropBalls()
 }
 
 func (p *player) String() string {
-	return fmt.Sprintf("%v, %v, %v, %v", p.addr(), p.ball, p.state, p.start)
+	return fmt.Sprintf("%v, %v, %v, %v", p.addr(), p.ballIDs(), p.getState(), p.start)
 }
@@ -0,0 +1,606 @@
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testLimits = Limits{MessagesPerSecond: 100, MessageBurst: 100}
+
+// newTestCourt creates a court in mode on a fake clock, which the tests step themselves rather than leaving it to
+// the clock.
+func newTestCourt(t *testing.T, mode modeT) *courtT {
+	c := newCourtWith(8, mode, newFakeClock(time.Date(2026, 1, 5, 12, 0, 0, 0, time.UTC)), rand.New(rand.NewSource(1)))
+	t.Cleanup(c.close)
+	return c
+}
+
+// joinTestPlayers puts n players on the court's wait list in order, each on its own fake connection. Their ids
+// are only used by the test.
+func joinTestPlayers(t *testing.T, c *courtT, n int) ([]*player, []*fakeConn) {
+	var players []*player
+	var conns []*fakeConn
+	for i := 0; i < n; i++ {
+		wsConn := newFakeConn(fmt.Sprintf("10.0.0.%d:4000", i+1))
+		p := newPlayer(c, wsConn, fmt.Sprintf("%s/player-%d", t.Name(), i), waiting, testLimits, nil)
+		if err := c.waiters.Add(p); err != nil {
+			t.Fatal(err)
+		}
+		players = append(players, p)
+		conns = append(conns, wsConn)
+	}
+	return players, conns
+}
+
+// seatedPlayers returns which of players is in each of the court's seats, -1 for an empty seat.
+func seatedPlayers(c *courtT, players []*player) []int {
+	seats := make([]int, len(c.seats))
+	for i, p := range c.seats {
+		seats[i] = indexOfPlayer(players, p)
+	}
+	return seats
+}
+
+// waitingPlayers returns which of players are on the court's wait list, in order.
+func waitingPlayers(c *courtT, players []*player) []int {
+	c.waiters.lock.RLock()
+	defer c.waiters.lock.RUnlock()
+
+	waiting := []int{}
+	for e := c.waiters.lst.Front(); e != nil; e = e.Next() {
+		waiting = append(waiting, indexOfPlayer(players, e.Value.(*player)))
+	}
+	return waiting
+}
+
+func indexOfPlayer(players []*player, p *player) int {
+	for i, q := range players {
+		if p != nil && q == p {
+			return i
+		}
+	}
+	return -1
+}
+
+func matchID(c *courtT) string {
+	c.matchLock.Lock()
+	defer c.matchLock.Unlock()
+
+	if c.match == nil {
+		return ""
+	}
+	return c.match.id
+}
+
+// useTestPlayerStore keeps player records in a file that lasts as long as the test.
+func useTestPlayerStore(t *testing.T) {
+	if err := EnablePlayerStore(filepath.Join(t.TempDir(), "players.json")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		saving.Wait()
+		playerStore = nil
+	})
+}
+
+// waitForRecord waits for a player's record to meet cond, records are saved in the background.
+func waitForRecord(t *testing.T, id string, cond func(r playerRecordT) bool) playerRecordT {
+	t.Helper()
+
+	var r playerRecordT
+	waitFor(t, "record of "+id, func() bool {
+		r, _ = playerStore.get(id)
+		return cond(r)
+	})
+	return r
+}
+
+// waitFor waits for cond to become true, which it must do soon.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCourtStepSeatsWaitingPlayers(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        modeT
+		players     int
+		wantSeats   []int
+		wantWaiting []int
+		wantMatch   bool
+	}{
+		{name: "empty court", mode: classicMode{}, players: 0, wantSeats: []int{-1, -1}, wantWaiting: []int{}},
+		{name: "one player waits for an opponent", mode: classicMode{}, players: 1, wantSeats: []int{0, -1},
+			wantWaiting: []int{}},
+		{name: "two players start a match", mode: classicMode{}, players: 2, wantSeats: []int{0, 1},
+			wantWaiting: []int{}, wantMatch: true},
+		{name: "third player keeps waiting", mode: classicMode{}, players: 3, wantSeats: []int{0, 1},
+			wantWaiting: []int{2}, wantMatch: true},
+		{name: "doubles waits for a full court", mode: doublesMode{}, players: 3, wantSeats: []int{0, 1, 2, -1},
+			wantWaiting: []int{}},
+		{name: "doubles starts with four", mode: doublesMode{}, players: 5, wantSeats: []int{0, 1, 2, 3},
+			wantWaiting: []int{4}, wantMatch: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCourt(t, tt.mode)
+			players, _ := joinTestPlayers(t, c, tt.players)
+
+			c.step()
+
+			if got := seatedPlayers(c, players); !reflect.DeepEqual(got, tt.wantSeats) {
+				t.Errorf("seats = %v, want %v", got, tt.wantSeats)
+			}
+			if got := waitingPlayers(c, players); !reflect.DeepEqual(got, tt.wantWaiting) {
+				t.Errorf("waiting = %v, want %v", got, tt.wantWaiting)
+			}
+			if got := matchID(c) != ""; got != tt.wantMatch {
+				t.Errorf("match started = %t, want %t", got, tt.wantMatch)
+			}
+			for i, p := range c.seats {
+				if p != nil && (p.getState() != playing || p.seat != i || p.side != c.layout[i].Side) {
+					t.Errorf("seat %d player state %d seat %d side %s, want playing at seat %d on %s", i, p.getState(),
+						p.seat, p.side, i, c.layout[i].Side)
+				}
+			}
+		})
+	}
+}
+
+func TestCourtStepLosers(t *testing.T) {
+	tests := []struct {
+		name           string
+		mode           modeT
+		players        int
+		rematchTimeout int
+		loser          int // the player who lets the ball past
+		wantSeats      []int
+		wantWaiting    []int
+		wantNewMatch   bool
+		wantRematch    bool
+	}{
+		{name: "left loser goes to the back of the queue", mode: classicMode{}, players: 3, loser: 0,
+			wantSeats: []int{2, 1}, wantWaiting: []int{0}, wantNewMatch: true},
+		{name: "right loser goes to the back of the queue", mode: classicMode{}, players: 3, loser: 1,
+			wantSeats: []int{0, 2}, wantWaiting: []int{1}, wantNewMatch: true},
+		{name: "loser plays again when nobody is waiting", mode: classicMode{}, players: 2, loser: 1,
+			wantSeats: []int{0, 1}, wantWaiting: []int{}, wantNewMatch: true},
+		{name: "rematch keeps the seat empty", mode: classicMode{}, players: 2, rematchTimeout: 15, loser: 0,
+			wantSeats: []int{-1, 1}, wantWaiting: []int{}, wantRematch: true},
+		{name: "no rematch while others wait", mode: classicMode{}, players: 3, rematchTimeout: 15, loser: 0,
+			wantSeats: []int{2, 1}, wantWaiting: []int{0}, wantNewMatch: true},
+		{name: "doubles team loses together", mode: doublesMode{}, players: 5, loser: 3,
+			wantSeats: []int{0, 1, 4, 2}, wantWaiting: []int{3}, wantNewMatch: true},
+		{name: "ring plays on after a knock out", mode: &ringMode{size: 3}, players: 4, loser: 1,
+			wantSeats: []int{0, -1, 2}, wantWaiting: []int{3, 1}},
+	}
+
+	defer ConfigureRematches(rematchSettings)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ConfigureRematches(RematchSettings{Timeout: tt.rematchTimeout})
+			c := newTestCourt(t, tt.mode)
+			players, _ := joinTestPlayers(t, c, tt.players)
+
+			c.step()
+			first := matchID(c)
+			if first == "" {
+				t.Fatal("match not started")
+			}
+
+			players[tt.loser].handleLostMsg(0)
+			c.step()
+
+			if got := seatedPlayers(c, players); !reflect.DeepEqual(got, tt.wantSeats) {
+				t.Errorf("seats = %v, want %v", got, tt.wantSeats)
+			}
+			if got := waitingPlayers(c, players); !reflect.DeepEqual(got, tt.wantWaiting) {
+				t.Errorf("waiting = %v, want %v", got, tt.wantWaiting)
+			}
+			if got := matchID(c); (got != "" && got != first) != tt.wantNewMatch {
+				t.Errorf("match = %q after %q, want new match %t", got, first, tt.wantNewMatch)
+			}
+			if got := c.rematch != nil; got != tt.wantRematch {
+				t.Errorf("rematch offered = %t, want %t", got, tt.wantRematch)
+			}
+			for _, i := range tt.wantWaiting {
+				if players[i].getState() != waiting {
+					t.Errorf("waiting player %d state = %d, want waiting", i, players[i].getState())
+				}
+			}
+		})
+	}
+}
+
+func TestCourtStepDeadPlayers(t *testing.T) {
+	tests := []struct {
+		name         string
+		players      int
+		disconnect   int
+		loser        int // a player who then lets the ball past, -1 for nobody
+		wantSeats    []int
+		wantWaiting  []int
+		wantNewMatch bool
+		wantNoMatch  bool
+	}{
+		{name: "seated player replaced from the wait list", players: 3, disconnect: 0, loser: -1,
+			wantSeats: []int{2, 1}, wantWaiting: []int{}, wantNewMatch: true},
+		{name: "seated player leaves nobody to play", players: 2, disconnect: 1, loser: -1,
+			wantSeats: []int{0, -1}, wantWaiting: []int{}, wantNoMatch: true},
+		{name: "waiting player is pruned", players: 4, disconnect: 2, loser: -1, wantSeats: []int{0, 1},
+			wantWaiting: []int{3}},
+		{name: "waiting player is never seated", players: 4, disconnect: 2, loser: 0, wantSeats: []int{3, 1},
+			wantWaiting: []int{0}, wantNewMatch: true},
+	}
+
+	defer ConfigureRematches(rematchSettings)
+	ConfigureRematches(RematchSettings{})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCourt(t, classicMode{})
+			players, conns := joinTestPlayers(t, c, tt.players)
+
+			c.step()
+			first := matchID(c)
+
+			conns[tt.disconnect].Close()
+			waitFor(t, "player to die", func() bool { return players[tt.disconnect].getState() == dead })
+			if tt.loser >= 0 {
+				players[tt.loser].handleLostMsg(0)
+			}
+			c.step()
+			c.waiters.pruneDead()
+
+			if got := seatedPlayers(c, players); !reflect.DeepEqual(got, tt.wantSeats) {
+				t.Errorf("seats = %v, want %v", got, tt.wantSeats)
+			}
+			if got := waitingPlayers(c, players); !reflect.DeepEqual(got, tt.wantWaiting) {
+				t.Errorf("waiting = %v, want %v", got, tt.wantWaiting)
+			}
+			got := matchID(c)
+			if tt.wantNoMatch && got != "" {
+				t.Errorf("match = %q, want none", got)
+			}
+			if !tt.wantNoMatch && (got != first) != tt.wantNewMatch {
+				t.Errorf("match = %q after %q, want new match %t", got, first, tt.wantNewMatch)
+			}
+		})
+	}
+}
+
+func TestCourtStepStalledConnection(t *testing.T) {
+	c := newTestCourt(t, classicMode{})
+	players, conns := joinTestPlayers(t, c, 3)
+	c.step()
+
+	// more messages than fit in the queue of a connection that isn't being written to
+	conns[1].stall()
+	queued := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*sendBuffer; i++ {
+			players[1].sendBallInMsg(i, 500, 180, 3)
+		}
+		close(queued)
+	}()
+	select {
+	case <-queued:
+	case <-time.After(time.Second):
+		t.Fatal("queueing messages for a stalled connection blocked")
+	}
+
+	if got := players[1].getState(); got != dead {
+		t.Errorf("stalled player state = %d, want dead", got)
+	}
+	c.step()
+	if got, want := seatedPlayers(c, players), []int{0, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("seats = %v, want %v", got, want)
+	}
+	if !conns[1].isClosed() {
+		t.Error("stalled connection wasn't closed")
+	}
+}
+
+func TestCourtStepServes(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      modeT
+		players   int
+		steps     int
+		wantBalls []int // balls on each seat
+	}{
+		{name: "no serve without an opponent", mode: classicMode{}, players: 1, steps: 1, wantBalls: []int{0, 0}},
+		{name: "classic serves to the left", mode: classicMode{}, players: 2, steps: 1, wantBalls: []int{1, 0}},
+		{name: "one ball in play at a time", mode: classicMode{}, players: 2, steps: 3, wantBalls: []int{1, 0}},
+		{name: "doubles serves to the left team", mode: doublesMode{}, players: 4, steps: 1,
+			wantBalls: []int{1, 1, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCourt(t, tt.mode)
+			players, conns := joinTestPlayers(t, c, tt.players)
+
+			for i := 0; i < tt.steps; i++ {
+				c.step()
+			}
+
+			served := 0
+			for i, want := range tt.wantBalls {
+				p := c.seats[i]
+				got := 0
+				if p != nil {
+					got = len(p.ballIDs())
+				}
+				if got != want {
+					t.Errorf("seat %d has %d balls, want %d", i, got, want)
+				}
+				if want == 0 || p == nil {
+					continue
+				}
+				served++
+
+				side := c.layout[i].Side
+				wsConn := conns[indexOfPlayer(players, p)]
+				waitFor(t, "ball message", func() bool { return len(ballMsgs(wsConn.sent())) > 0 })
+				checkServe(t, side, ballMsgs(wsConn.sent())[0])
+			}
+			if served > 0 && c.nextBall != 1 {
+				t.Errorf("served %d balls, want 1", c.nextBall)
+			}
+		})
+	}
+}
+
+// ballMsgs returns the ball messages in msgs.
+func ballMsgs(msgs []string) []string {
+	var balls []string
+	for _, msg := range msgs {
+		if strings.HasPrefix(msg, "B,") {
+			balls = append(balls, msg)
+		}
+	}
+	return balls
+}
+
+// checkServe checks that a ball message serves the ball somewhere on the court towards the end of side, at a
+// playable speed.
+func checkServe(t *testing.T, side sideT, msg string) {
+	t.Helper()
+
+	parts := strings.Split(msg, ",")
+	if len(parts) != 5 {
+		t.Fatalf("ball message %q, want 5 fields", msg)
+	}
+	ints := make([]int, 4)
+	for i := range ints {
+		v, err := strconv.Atoi(parts[i+1])
+		if err != nil {
+			t.Fatalf("ball message %q: %s", msg, err)
+		}
+		ints[i] = v
+	}
+	yPos, angle, speed := ints[0], ints[1], ints[2]
+
+	if yPos < 100 || yPos >= 900 {
+		t.Errorf("served at y %d, want 100 to 899", yPos)
+	}
+	if speed < 2 || speed > 5 {
+		t.Errorf("served at speed %d, want 2 to 5", speed)
+	}
+	towardsLeft := angle >= 135 && angle < 225
+	if towardsLeft != (side == left) {
+		t.Errorf("served at angle %d to the %s side", angle, side)
+	}
+}
+
+func TestServeAngle(t *testing.T) {
+	tests := []struct {
+		side  sideT
+		angle int
+		want  int
+	}{
+		{left, 180, 180},
+		{left, 135, 135},
+		{right, 180, 0},
+		{right, 135, 45},
+		{right, 224, 316},
+	}
+
+	for _, tt := range tests {
+		if got := serveAngle(tt.side, tt.angle); got != tt.want {
+			t.Errorf("serveAngle(%s, %d) = %d, want %d", tt.side, tt.angle, got, tt.want)
+		}
+	}
+}
+
+func TestCourtStepRingKnockOutsCount(t *testing.T) {
+	defer ConfigureRematches(rematchSettings)
+	ConfigureRematches(RematchSettings{})
+	useTestPlayerStore(t)
+
+	c := newTestCourt(t, &ringMode{size: 3})
+	players, _ := joinTestPlayers(t, c, 3)
+	c.step()
+
+	// knocked out first, then second, leaving the last player the winner
+	for _, loser := range []int{0, 1} {
+		players[loser].handleLostMsg(0)
+		c.step()
+		waitForRecord(t, players[loser].id, func(r playerRecordT) bool { return r.Stats.Played == 1 })
+	}
+	waitForRecord(t, players[2].id, func(r playerRecordT) bool { return r.Stats.Played == 1 })
+
+	tests := []struct {
+		player        int
+		wantWins      int
+		wantRated     int   // rated games, one against each player knocked out while they were still in
+		wantOpponents []int // in the player's match history
+	}{
+		{player: 0, wantWins: 0, wantRated: 1, wantOpponents: []int{1, 2}},
+		{player: 1, wantWins: 0, wantRated: 2, wantOpponents: []int{2}},
+		{player: 2, wantWins: 1, wantRated: 2, wantOpponents: []int{1}},
+	}
+
+	for _, tt := range tests {
+		id := players[tt.player].id
+		r := waitForRecord(t, id, func(r playerRecordT) bool { return r.Matches == tt.wantRated })
+		if r.Stats.Played != 1 || r.Stats.Wins != tt.wantWins {
+			t.Errorf("%s played %d won %d, want played 1 won %d", id, r.Stats.Played, r.Stats.Wins, tt.wantWins)
+		}
+		var opponents []string
+		for _, i := range tt.wantOpponents {
+			opponents = append(opponents, players[i].id)
+		}
+		if len(r.Recent) != 1 || !reflect.DeepEqual(r.Recent[0].Opponents, opponents) {
+			t.Errorf("%s recent matches %+v, want one against %v", id, r.Recent, opponents)
+		}
+	}
+
+	p0, _ := playerStore.get(players[0].id)
+	p2, _ := playerStore.get(players[2].id)
+	if p0.Rating >= initialRating || p2.Rating <= initialRating {
+		t.Errorf("ratings %s %.0f, %s %.0f, want the first out below %.0f and the winner above", p0.ID, p0.Rating,
+			p2.ID, p2.Rating, initialRating)
+	}
+}
+
+func TestCourtStepRematch(t *testing.T) {
+	tests := []struct {
+		name        string
+		answers     []int // of players 0 and 1
+		joins       bool  // another player joins while the rematch is on offer
+		wantSeats   []int
+		wantWaiting []int
+		wantOffer   bool
+	}{
+		{name: "both accept", answers: []int{rematchAccepted, rematchAccepted}, wantSeats: []int{0, 1},
+			wantWaiting: []int{}},
+		{name: "not answered yet", answers: []int{rematchAccepted, rematchUnanswered}, wantSeats: []int{-1, 1},
+			wantWaiting: []int{}, wantOffer: true},
+		{name: "loser declines", answers: []int{rematchDeclined, rematchUnanswered}, wantSeats: []int{0, 1},
+			wantWaiting: []int{}},
+		{name: "someone joins before both accept", answers: []int{rematchAccepted, rematchAccepted}, joins: true,
+			wantSeats: []int{2, 1}, wantWaiting: []int{0}},
+		{name: "someone joins before anyone answers", answers: []int{rematchUnanswered, rematchUnanswered},
+			joins: true, wantSeats: []int{2, 1}, wantWaiting: []int{0}},
+	}
+
+	defer ConfigureRematches(rematchSettings)
+	ConfigureRematches(RematchSettings{Timeout: 15})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCourt(t, classicMode{})
+			players, _ := joinTestPlayers(t, c, 2)
+			c.step()
+
+			players[0].handleLostMsg(0)
+			c.step()
+			if c.rematch == nil {
+				t.Fatal("rematch not offered")
+			}
+
+			if tt.joins {
+				joined, _ := joinTestPlayers(t, c, 1)
+				players = append(players, joined...)
+			}
+			for i, answer := range tt.answers {
+				players[i].answerRematch(answer)
+			}
+			c.step()
+
+			if got := seatedPlayers(c, players); !reflect.DeepEqual(got, tt.wantSeats) {
+				t.Errorf("seats = %v, want %v", got, tt.wantSeats)
+			}
+			if got := waitingPlayers(c, players); !reflect.DeepEqual(got, tt.wantWaiting) {
+				t.Errorf("waiting = %v, want %v", got, tt.wantWaiting)
+			}
+			if got := c.rematch != nil; got != tt.wantOffer {
+				t.Errorf("rematch on offer = %t, want %t", got, tt.wantOffer)
+			}
+		})
+	}
+}
+
+func TestCourtStepLeaverLoses(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        modeT
+		rotation    rotationT
+		players     int
+		leavers     []int
+		wantWon     map[int]bool // players the match is over for, and whether they won it
+		wantSeats   []int
+		wantWaiting []int
+	}{
+		{name: "classic", mode: classicMode{}, rotation: kingRotation{}, players: 3, leavers: []int{1},
+			wantWon: map[int]bool{0: true, 1: false}, wantSeats: []int{0, 2}, wantWaiting: []int{}},
+		{name: "doubles team forfeits", mode: doublesMode{}, rotation: kingRotation{}, players: 6, leavers: []int{0},
+			wantWon: map[int]bool{0: false, 1: false, 2: true, 3: true}, wantSeats: []int{4, 5, 2, 3},
+			wantWaiting: []int{1}},
+		{name: "doubles rotates", mode: doublesMode{}, rotation: bothRotation{}, players: 6, leavers: []int{0},
+			wantWon: map[int]bool{0: false, 1: false, 2: true, 3: true}, wantSeats: []int{4, 5, 1, 2},
+			wantWaiting: []int{3}},
+		{name: "ring plays on", mode: &ringMode{size: 3}, rotation: kingRotation{}, players: 3, leavers: []int{2},
+			wantWon: map[int]bool{2: false}, wantSeats: []int{0, 1, -1}, wantWaiting: []int{}},
+		{name: "everyone leaves", mode: classicMode{}, rotation: kingRotation{}, players: 2, leavers: []int{0, 1},
+			wantWon: map[int]bool{}, wantSeats: []int{-1, -1}, wantWaiting: []int{}},
+	}
+
+	defer ConfigureRematches(rematchSettings)
+	ConfigureRematches(RematchSettings{})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestPlayerStore(t)
+			c := newTestCourt(t, tt.mode)
+			c.rotation = tt.rotation
+			players, conns := joinTestPlayers(t, c, tt.players)
+			c.step()
+
+			for _, i := range tt.leavers {
+				conns[i].Close()
+				waitFor(t, "player to leave", func() bool { return players[i].getState() == dead })
+			}
+			c.step()
+
+			if got := seatedPlayers(c, players); !reflect.DeepEqual(got, tt.wantSeats) {
+				t.Errorf("seats = %v, want %v", got, tt.wantSeats)
+			}
+			if got := waitingPlayers(c, players); !reflect.DeepEqual(got, tt.wantWaiting) {
+				t.Errorf("waiting = %v, want %v", got, tt.wantWaiting)
+			}
+
+			saving.Wait()
+			for i, p := range players {
+				won, over := tt.wantWon[i]
+				if !over {
+					if r, _ := playerStore.get(p.id); r.Stats.Played != 0 {
+						t.Errorf("%s the match isn't over for has played %d", p.id, r.Stats.Played)
+					}
+					continue
+				}
+				r := waitForRecord(t, p.id, func(r playerRecordT) bool { return r.Stats.Played == 1 })
+				if gotWon := r.Stats.Wins == 1; gotWon != won || r.Matches != 1 {
+					t.Errorf("%s won %t rated %d times, want won %t rated once", p.id, gotWon, r.Matches, won)
+				}
+			}
+		})
+	}
+}
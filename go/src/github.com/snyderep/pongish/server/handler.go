@@ -1,52 +1,105 @@
 package server
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 	"github.com/gorilla/websocket"
 	"github.com/rs/xid"
 )
 
-var store = sessions.NewCookieStore([]byte("BqEKmLBysSblvwtoB4G8VjIu"))
-
 // PongishHandlerProvider provides http handlers.
 type PongishHandlerProvider struct {
 	renderer       TemplateRenderer
 	wsGameEndpoint string
 	wsUpgrader     websocket.Upgrader
+	limits         Limits
+	sessions       sessions.Store
 }
 
-// NewPongishHandlerProvider creates a new PongishHandlerProvider
-func NewPongishHandlerProvider(renderer TemplateRenderer, wsGameEndpoint string, wsCheckOrigin bool) *PongishHandlerProvider {
-	var upgrader websocket.Upgrader
+// NewPongishHandlerProvider creates a new PongishHandlerProvider. When wsCheckOrigin is set websocket upgrades
+// are only accepted from the server's own host or from one of allowedOrigins.
+func NewPongishHandlerProvider(renderer TemplateRenderer, wsGameEndpoint string, wsCheckOrigin bool,
+	allowedOrigins []string, limits Limits, sessionStore sessions.Store) *PongishHandlerProvider {
+	upgrader := websocket.Upgrader{
+		CheckOrigin: newOriginChecker(allowedOrigins).check,
+	}
 	if !wsCheckOrigin {
-		upgrader = websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool { return true },
-		}
+		upgrader.CheckOrigin = func(r *http.Request) bool { return true }
 	}
 	return &PongishHandlerProvider{
 		renderer:       renderer,
 		wsGameEndpoint: wsGameEndpoint,
 		wsUpgrader:     upgrader,
+		limits:         limits,
+		sessions:       sessionStore,
 	}
 }
 
 func (p *PongishHandlerProvider) screenHandler(w http.ResponseWriter, r *http.Request) {
 	// Get a session. Get() always returns a session, even if empty.
-	session, err := store.Get(r, "pongish-a")
+	session, err := p.sessions.Get(r, sessionName)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		// most likely signed with a key that has since been retired, start a new session
+		log.Printf("discarding unreadable session: %s\n", err)
 	}
 
 	if session.IsNew {
 		guid := xid.New()
 		session.Values["id"] = guid.String()
+		if err := session.Save(r, w); err != nil {
+			log.Printf("error saving session: %s\n", err)
+		}
 	}
 
 	data := make(map[string]interface{})
-	data["WsGameEndpoint"] = p.wsGameEndpoint
+	data["WsGameEndpoint"] = p.gameEndpoint(r)
+	data["Boards"] = boardNames()
+	data["Themes"] = themeNames
+	data["WinnerStays"] = rotation.WinnerStays
+	if playerStore != nil {
+		data["Ratings"] = true
+		if id, ok := session.Values["id"].(string); ok {
+			if rec, ok := playerStore.get(id); ok {
+				data["Rating"] = formatRating(rec)
+				data["PlayerID"] = id
+			}
+		}
+	}
+
+	if code := r.URL.Query().Get("room"); code != "" {
+		code = normalizeRoomCode(code)
+		crt, err := rooms.court(code)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		data["WsGameEndpoint"] = p.gameEndpoint(r) + "?room=" + url.QueryEscape(code)
+		data["RoomCode"] = code
+		data["RoomURL"] = roomURL(r, code)
+		data["RoomMode"] = crt.mode.name()
+		data["RoomPowerUps"] = crt.hasPowerUps()
+		data["RoomBoard"] = crt.boardName()
+		data["RoomTheme"] = crt.theme
+
+		if crt.maxSegments() > 0 {
+			data["DisplayURL"] = roomURL(r, code) + "&display=1"
+			if isDisplay(r) {
+				data["WsGameEndpoint"] = data["WsGameEndpoint"].(string) + "&display=1"
+				data["Display"] = true
+			}
+		}
+	}
 
 	if err := p.renderer.renderTemplate(w, "_screen.tmpl", data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -54,19 +107,372 @@ func (p *PongishHandlerProvider) screenHandler(w http.ResponseWriter, r *http.Re
 }
 
 func (p *PongishHandlerProvider) gameHandler(w http.ResponseWriter, r *http.Request) {
+	join := func(add func(c *courtT) error) error { return add(court) }
+	if code := r.URL.Query().Get("room"); code != "" {
+		if _, err := rooms.court(code); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		// the room is looked up again when joining, it may have been closed while the connection was upgraded
+		join = func(add func(c *courtT) error) error { return rooms.join(code, add) }
+	}
+
+	ip := remoteIP(r)
+	release, err := connTracker.acquire(ip, p.sessionID(r), p.limits)
+	if err != nil {
+		log.Printf("rejecting game connection from %s: %s\n", ip, err)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+
 	c, err := p.wsUpgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("server: websocket upgrade: %s\n", err)
+		release()
 		return
 	}
 
-	if err := addPlayer(c); err != nil {
+	add := addPlayer
+	if isDisplay(r) {
+		add = addDisplay
+	}
+
+	err = join(func(crt *courtT) error { return add(crt, c, p.sessionID(r), p.limits, release) })
+	if err != nil {
 		log.Printf("error adding player: %s\n", err)
 		c.Close()
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		release()
 	}
 }
 
+// isDisplay returns true if the request is for a display only segment of a court rather than a player.
+func isDisplay(r *http.Request) bool {
+	return r.URL.Query().Get("display") != ""
+}
+
 func (p *PongishHandlerProvider) homeHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/screen", http.StatusFound)
 }
+
+// roomHandler creates a private room and sends the creator to its screen.
+func (p *PongishHandlerProvider) roomHandler(w http.ResponseWriter, r *http.Request) {
+	code, err := rooms.create(roomOptions{
+		mode:       r.FormValue("mode"),
+		powerUps:   r.FormValue("powerups") != "",
+		board:      r.FormValue("board"),
+		theme:      r.FormValue("theme"),
+		matchmaker: r.FormValue("matchmaker"),
+		rotation:   r.FormValue("rotation"),
+	}, remoteIP(r), p.sessionID(r), p.limits)
+	if err == ErrTooManyRoomsCreated {
+		log.Printf("rejecting room creation from %s: %s\n", remoteIP(r), err)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	} else if err == ErrUnknownMode || err == ErrUnknownBoard || err == ErrUnknownTheme || err == ErrUnknownMatchmaker ||
+		err == ErrUnknownRotation {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	http.Redirect(w, r, "/screen?room="+url.QueryEscape(code), http.StatusSeeOther)
+}
+
+// replayHandler serves the replay viewer for a recorded match.
+func (p *PongishHandlerProvider) replayHandler(w http.ResponseWriter, r *http.Request) {
+	matchID := mux.Vars(r)["matchID"]
+
+	events, ok := loadMatchEvents(w, matchID)
+	if !ok {
+		return
+	}
+
+	eventsJSON, err := json.Marshal(events)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["MatchID"] = matchID
+	data["Events"] = string(eventsJSON)
+
+	if err := p.renderer.renderTemplate(w, "_replay.tmpl", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// replayEventsHandler serves the raw events of a recorded match as JSON.
+func (p *PongishHandlerProvider) replayEventsHandler(w http.ResponseWriter, r *http.Request) {
+	events, ok := loadMatchEvents(w, mux.Vars(r)["matchID"])
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(events); err != nil {
+		log.Printf("error writing match events: %s\n", err)
+	}
+}
+
+// loadMatchEvents loads a match recording, writing an error response and returning false if it can't.
+func loadMatchEvents(w http.ResponseWriter, matchID string) ([]matchEvent, bool) {
+	if recordStore == nil {
+		http.Error(w, "server: matches are not being recorded", http.StatusNotFound)
+		return nil, false
+	}
+
+	events, err := recordStore.load(matchID)
+	if err == ErrUnknownMatch {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return events, true
+}
+
+// leaderboardSize is the most players shown on the leaderboard.
+const leaderboardSize = 100
+
+// leaderboardHandler serves the rated players, highest rated first.
+func (p *PongishHandlerProvider) leaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	if playerStore == nil {
+		http.Error(w, "server: players are not being rated", http.StatusNotFound)
+		return
+	}
+
+	you := p.sessionID(r)
+
+	var rows []map[string]interface{}
+	for i, rec := range leaderboard(leaderboardSize) {
+		rows = append(rows, map[string]interface{}{
+			"Rank":        i + 1,
+			"ID":          rec.ID,
+			"Rating":      formatRating(rec),
+			"RD":          int(math.Floor(rec.RD + 0.5)),
+			"Matches":     rec.Matches,
+			"Provisional": rec.provisional(),
+			"You":         rec.ID == you,
+		})
+	}
+
+	data := make(map[string]interface{})
+	data["Players"] = rows
+
+	if err := p.renderer.renderTemplate(w, "_leaderboard.tmpl", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// playerHandler serves a player's profile: their rating, stats and recent matches.
+func (p *PongishHandlerProvider) playerHandler(w http.ResponseWriter, r *http.Request) {
+	rec, ok := findPlayer(w, mux.Vars(r)["playerID"])
+	if !ok {
+		return
+	}
+
+	stats := rec.Stats
+
+	var opponents []map[string]interface{}
+	for _, id := range rec.favouriteOpponents(5) {
+		opponents = append(opponents, map[string]interface{}{"ID": id, "Matches": rec.Opponents[id]})
+	}
+
+	var badges []map[string]interface{}
+	for _, b := range rec.Badges {
+		badges = append(badges, map[string]interface{}{
+			"Name":        b.Name,
+			"Description": b.Description,
+			"When":        time.Unix(b.T, 0).Format("2 Jan 2006"),
+		})
+	}
+
+	var recent []map[string]interface{}
+	for i := len(rec.Recent) - 1; i >= 0; i-- {
+		m := rec.Recent[i]
+		recent = append(recent, map[string]interface{}{
+			"Match":        m.Match,
+			"When":         time.Unix(m.T, 0).Format("2 Jan 2006 15:04"),
+			"Mode":         m.Mode,
+			"Won":          m.Won,
+			"Opponents":    m.Opponents,
+			"Duration":     formatMillis(m.Millis),
+			"Hits":         m.Hits,
+			"Misses":       m.Misses,
+			"LongestRally": m.LongestRally,
+		})
+	}
+
+	data := make(map[string]interface{})
+	data["ID"] = rec.ID
+	data["You"] = rec.ID == p.sessionID(r)
+	data["Rating"] = formatRating(rec)
+	data["RD"] = int(math.Floor(rec.RD + 0.5))
+	data["Provisional"] = rec.provisional()
+	data["Trend"] = ratingTrend(rec.History, 300, 60)
+	data["Stats"] = stats
+	data["WinRate"] = stats.winRate()
+	data["AverageRally"] = fmt.Sprintf("%.1f", stats.averageRally())
+	data["TimePlayed"] = formatMillis(stats.PlayedMillis)
+	data["Opponents"] = opponents
+	data["Badges"] = badges
+	data["Recent"] = recent
+	data["Replays"] = recordStore != nil
+
+	if err := p.renderer.renderTemplate(w, "_player.tmpl", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// playerRatingsHandler serves a player's rating and how it has changed over their recent matches as JSON.
+func (p *PongishHandlerProvider) playerRatingsHandler(w http.ResponseWriter, r *http.Request) {
+	rec, ok := findPlayer(w, mux.Vars(r)["playerID"])
+	if !ok {
+		return
+	}
+
+	ratings := struct {
+		playerRecordT
+		Provisional bool `json:"provisional"`
+	}{rec, rec.provisional()}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ratings); err != nil {
+		log.Printf("error writing player ratings: %s\n", err)
+	}
+}
+
+// exportHandler serves an export of matches, rallies, hits or players as CSV or JSON, see ExportOptions. The
+// format, from and until are query parameters. Only requests with the export token get one, see ExportSettings.
+func (p *PongishHandlerProvider) exportHandler(w http.ResponseWriter, r *http.Request) {
+	if exportSettings.Token == "" {
+		http.Error(w, "server: exports are only available from the export command", http.StatusNotFound)
+		return
+	}
+	if !exportAllowed(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="pongish export"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+	opts, err := NewExportOptions(mux.Vars(r)["kind"], q.Get("format"), q.Get("from"), q.Get("until"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := opts.available(); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", opts.contentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=pongish-%s.%s", opts.Kind, opts.Format))
+	if err := Export(w, opts); err != nil {
+		// too late to change the response, it's cut short
+		log.Printf("error exporting %s: %s\n", opts.Kind, err)
+	}
+}
+
+// findPlayer returns a player's record, writing an error response and returning false if there isn't one.
+func findPlayer(w http.ResponseWriter, id string) (playerRecordT, bool) {
+	if playerStore == nil {
+		http.Error(w, "server: players are not being rated", http.StatusNotFound)
+		return playerRecordT{}, false
+	}
+
+	rec, ok := playerStore.get(id)
+	if !validPlayerID(id) || !ok {
+		http.Error(w, ErrUnknownPlayer.Error(), http.StatusNotFound)
+		return playerRecordT{}, false
+	}
+
+	return rec, true
+}
+
+// formatMillis returns a number of milliseconds as a duration to the second, like 12m5s.
+func formatMillis(millis int64) string {
+	return (time.Duration(millis) * time.Millisecond).Round(time.Second).String()
+}
+
+// ratingTrend returns the points of a line through a player's ratings, scaled to fit width by height, for an
+// SVG polyline. It is empty without at least two ratings.
+func ratingTrend(history []ratingChangeT, width int, height int) string {
+	if len(history) < 2 {
+		return ""
+	}
+
+	low, high := history[0].Rating, history[0].Rating
+	for _, c := range history {
+		low, high = math.Min(low, c.Rating), math.Max(high, c.Rating)
+	}
+	if high == low {
+		high = low + 1
+	}
+
+	points := make([]string, len(history))
+	for i, c := range history {
+		x := float64(i) * float64(width) / float64(len(history)-1)
+		y := float64(height) - (c.Rating-low)*float64(height)/(high-low)
+		points[i] = fmt.Sprintf("%.1f,%.1f", x, y)
+	}
+	return strings.Join(points, " ")
+}
+
+// formatRating returns a player's rating rounded for display, with a question mark if it's provisional.
+func formatRating(r playerRecordT) string {
+	rating := strconv.Itoa(int(math.Floor(r.Rating + 0.5)))
+	if r.provisional() {
+		rating += "?"
+	}
+	return rating
+}
+
+// gameEndpoint returns the websocket game endpoint for the page being served. With no endpoint configured it is
+// derived from the request, and a page served over https always gets a wss:// endpoint since browsers block
+// ws:// from secure pages.
+func (p *PongishHandlerProvider) gameEndpoint(r *http.Request) string {
+	if p.wsGameEndpoint == "" {
+		scheme := "ws"
+		if r.TLS != nil {
+			scheme = "wss"
+		}
+		u := url.URL{Scheme: scheme, Host: r.Host, Path: "/game"}
+		return u.String()
+	}
+
+	if r.TLS != nil && strings.HasPrefix(p.wsGameEndpoint, "ws://") {
+		return "wss://" + strings.TrimPrefix(p.wsGameEndpoint, "ws://")
+	}
+
+	return p.wsGameEndpoint
+}
+
+// roomURL builds the shareable screen URL for a room as seen by the requesting browser.
+func roomURL(r *http.Request, code string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	u := url.URL{Scheme: scheme, Host: r.Host, Path: "/screen", RawQuery: "room=" + url.QueryEscape(code)}
+
+	return u.String()
+}
+
+// sessionID returns the id minted by the screen handler, or an empty string if the request has no session.
+func (p *PongishHandlerProvider) sessionID(r *http.Request) string {
+	session, err := p.sessions.Get(r, sessionName)
+	if err != nil || session.IsNew {
+		return ""
+	}
+
+	id, _ := session.Values["id"].(string)
+
+	return id
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limits contains the abuse protection limits applied to game websocket connections and private room creation.
+// A zero value disables the corresponding limit.
+type Limits struct {
+	// MaxConnsPerIP is the maximum number of concurrent game connections from one remote IP.
+	MaxConnsPerIP int
+	// MaxConnsPerSession is the maximum number of concurrent game connections for one browser session.
+	MaxConnsPerSession int
+	// MessagesPerSecond is the sustained rate of messages a player may send.
+	MessagesPerSecond int
+	// MessageBurst is the number of messages a player may send in a burst above MessagesPerSecond.
+	MessageBurst int
+	// MaxViolations is the number of protocol violations tolerated before a player is disconnected.
+	MaxViolations int
+	// MaxRoomsPerIP is the maximum number of open private rooms created from one remote IP.
+	MaxRoomsPerIP int
+	// MaxRoomsPerSession is the maximum number of open private rooms created by one browser session.
+	MaxRoomsPerSession int
+}
+
+// connTrackerT counts open game connections per remote IP and per session.
+type connTrackerT struct {
+	lock      sync.Mutex
+	byIP      map[string]int
+	bySession map[string]int
+}
+
+var connTracker = &connTrackerT{byIP: make(map[string]int), bySession: make(map[string]int)}
+
+// acquire reserves a connection slot for the ip and session, returning a func that releases it.
+// An empty session is not counted against the per session limit.
+func (t *connTrackerT) acquire(ip string, session string, limits Limits) (func(), error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if limits.MaxConnsPerIP > 0 && t.byIP[ip] >= limits.MaxConnsPerIP {
+		return nil, ErrTooManyConnections
+	}
+	if session != "" && limits.MaxConnsPerSession > 0 && t.bySession[session] >= limits.MaxConnsPerSession {
+		return nil, ErrTooManyConnections
+	}
+
+	t.byIP[ip]++
+	if session != "" {
+		t.bySession[session]++
+	}
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() { t.release(ip, session) })
+	}, nil
+}
+
+func (t *connTrackerT) release(ip string, session string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.byIP[ip]--; t.byIP[ip] <= 0 {
+		delete(t.byIP, ip)
+	}
+	if session != "" {
+		if t.bySession[session]--; t.bySession[session] <= 0 {
+			delete(t.bySession, session)
+		}
+	}
+}
+
+// rateLimiterT is a token bucket. It is not safe for concurrent use, each player's read pump owns one.
+type rateLimiterT struct {
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+	clock  clock
+}
+
+// newRateLimiter returns nil, which allows everything, if perSecond is not positive.
+func newRateLimiter(perSecond int, burst int, clk clock) *rateLimiterT {
+	if perSecond <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiterT{rate: float64(perSecond), burst: float64(burst), tokens: float64(burst), last: clk.Now(), clock: clk}
+}
+
+func (l *rateLimiterT) allow() bool {
+	if l == nil {
+		return true
+	}
+
+	now := l.clock.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+
+	return true
+}
+
+// remoteIP returns the IP of the remote end of the request without the port.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		log.Printf("unable to parse remote address %s: %s\n", r.RemoteAddr, err)
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// match event kinds
+const (
+	eventStart   = "start"   // the court is ready to play
+	eventServe   = "serve"   // the server put a new ball in play on Seat
+	eventHandoff = "handoff" // the ball crossed the net into Seat
+	eventHit     = "hit"     // Seat's paddle returned the ball, X and Y are where
+	eventPaddle  = "paddle"  // Seat's paddle is at Y and moving by Move per frame
+	eventLost    = "lost"    // Seat let the ball past their paddle
+	eventLeft    = "left"    // Seat's player left mid match, which loses it the same as letting the ball past
+	eventEnd     = "end"     // the match is over, Reason says why
+	eventPowerUp = "powerup" // an Item power-up appeared on Seat's half at X and Y
+	eventCollect = "collect" // Ball collected an Item power-up on Seat's half
+	eventPause   = "pause"   // Seat paused the match, balls and paddles stay where they are
+	eventResume  = "resume"  // play resumed after Seat's pause
+)
+
+// match end reasons
+const (
+	endLost      = "lost"          // a loss ended the match
+	endLeft      = "player left"   // a player leaving ended the match
+	endClosed    = "court closed"  // the court closed with the match still being played
+	endWalkedOut = "everyone left" // every player left at once, so nobody won or lost
+)
+
+// matchEvent is one entry in a match's timeline. Positions are in the coordinates of Seat's half of the court,
+// angles are in degrees in the whole court's frame, the same as in ball in (B) messages.
+type matchEvent struct {
+	T      int64   `json:"t"` // milliseconds since the match started
+	Kind   string  `json:"kind"`
+	Seat   int     `json:"seat"`
+	Side   sideT   `json:"side,omitempty"`
+	Lane   int     `json:"lane,omitempty"`
+	Ball   int     `json:"ball,omitempty"` // the id of the ball the event is about
+	X      int     `json:"x,omitempty"`
+	Y      int     `json:"y,omitempty"`
+	Angle  int     `json:"angle,omitempty"`
+	Speed  int     `json:"speed,omitempty"`
+	Move   int     `json:"move,omitempty"`
+	Reason string  `json:"reason,omitempty"`
+	Item   string  `json:"item,omitempty"`  // the kind of power-up
+	Match  string  `json:"match,omitempty"` // only on start events
+	Start  string  `json:"start,omitempty"` // only on start events, RFC 3339
+	Mode   string  `json:"mode,omitempty"`  // only on start events
+	Seats  []seatT `json:"seats,omitempty"` // only on start events, the court's layout
+	// only on start events, the id of the player in each seat, empty for players without one
+	Players []string `json:"players,omitempty"`
+	Board   *boardT  `json:"board,omitempty"` // only on start events, nil for an empty court
+}
+
+// matchT is a single match between the players on a court, from when the court is ready to play until the
+// mode decides a loss ends it or a player leaves.
+type matchT struct {
+	lock   sync.Mutex
+	id     string
+	start  time.Time
+	events []matchEvent
+	out    io.WriteCloser // nil when matches aren't being recorded
+	enc    *json.Encoder
+	over   bool
+	clock  clock
+	// the match's stats so far, see stats.go
+	seatStats map[int]*seatStatsT
+	rallyHits map[int]int // the hits so far in each ball's rally
+	rallies   []int       // the hits in each rally that has ended
+}
+
+func newMatch(clk clock, mode string, layout []seatT, players []string, board *boardT) *matchT {
+	m := &matchT{id: xid.New().String(), start: clk.Now(), clock: clk, seatStats: make(map[int]*seatStatsT),
+		rallyHits: make(map[int]int)}
+
+	if recordStore != nil {
+		out, err := recordStore.create(m.id)
+		if err != nil {
+			log.Printf("not recording match %s: %s\n", m.id, err)
+		} else {
+			m.out = out
+			m.enc = json.NewEncoder(out)
+		}
+	}
+
+	m.record(matchEvent{Kind: eventStart, Match: m.id, Start: m.start.Format(time.RFC3339Nano), Mode: mode, Seats: layout,
+		Players: players, Board: board})
+
+	return m
+}
+
+// record timestamps the event and appends it to the match.
+func (m *matchT) record(e matchEvent) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.over {
+		return
+	}
+
+	e.T = int64(m.clock.Now().Sub(m.start) / time.Millisecond)
+	m.events = append(m.events, e)
+	m.tally(e)
+
+	if m.enc != nil {
+		if err := m.enc.Encode(e); err != nil {
+			log.Printf("error recording match %s, recording stopped: %s\n", m.id, err)
+			m.out.Close()
+			m.out, m.enc = nil, nil
+		}
+	}
+}
+
+// finish records the end of the match. Nothing more is recorded afterwards.
+func (m *matchT) finish(reason string) {
+	m.record(matchEvent{Kind: eventEnd, Reason: reason})
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.over = true
+	if m.out != nil {
+		if err := m.out.Close(); err != nil {
+			log.Printf("error closing recording of match %s: %s\n", m.id, err)
+		}
+		m.out, m.enc = nil, nil
+	}
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// matchmakerT decides who on a court's wait list plays next.
+type matchmakerT interface {
+	// name identifies the matchmaker in config and when creating rooms.
+	name() string
+	// pick chooses up to n of the waiting players, in the order they joined the wait list, to play the seated
+	// players. It can choose fewer, or none, to keep players waiting for better opponents.
+	pick(waiting []*player, seated []*player, n int, now time.Time) []*player
+}
+
+const defaultMatchmaker = "fifo"
+
+// matchmakers are the available matchmakers by name.
+var matchmakers = map[string]func() matchmakerT{
+	"fifo":         func() matchmakerT { return fifoMatchmaker{} },
+	"rating":       func() matchmakerT { return ratingMatchmaker{settings: matchmaking} },
+	"least-recent": func() matchmakerT { return leastRecentMatchmaker{} },
+}
+
+// MatchmakingSettings are how the public court picks who plays next, and how rating matchmaking works on every
+// court.
+type MatchmakingSettings struct {
+	// Policy is the public court's matchmaker: fifo, rating or least-recent.
+	Policy string
+	// Band is the most two players' ratings can differ by to be matched as soon as they start waiting.
+	Band int
+	// Widen is how much the band widens for every second a player waits.
+	Widen int
+	// MaxBand is the most the band widens to, 0 widens it until anyone can be matched.
+	MaxBand int
+}
+
+var matchmaking = MatchmakingSettings{Policy: defaultMatchmaker, Band: 100, Widen: 10, MaxBand: 0}
+
+// ConfigureMatchmaking sets how players are matched from now on, the public court switches to the configured
+// policy straight away.
+func ConfigureMatchmaking(settings MatchmakingSettings) error {
+	matchmaking = settings
+
+	m, err := newMatchmaker(settings.Policy)
+	if err != nil {
+		return err
+	}
+
+	court.lock.Lock()
+	defer court.lock.Unlock()
+
+	court.matchmaker = m
+
+	return nil
+}
+
+// newMatchmaker returns the named matchmaker, the default matchmaker if name is empty.
+func newMatchmaker(name string) (matchmakerT, error) {
+	if name == "" {
+		name = defaultMatchmaker
+	}
+
+	newFn, ok := matchmakers[strings.ToLower(name)]
+	if !ok {
+		return nil, ErrUnknownMatchmaker
+	}
+
+	return newFn(), nil
+}
+
+// fifoMatchmaker plays whoever has waited longest.
+type fifoMatchmaker struct{}
+
+func (fifoMatchmaker) name() string {
+	return "fifo"
+}
+
+func (fifoMatchmaker) pick(waiting []*player, seated []*player, n int, now time.Time) []*player {
+	if len(waiting) > n {
+		return waiting[:n]
+	}
+	return waiting
+}
+
+// ratingMatchmaker only plays players whose ratings are close to those already seated. The band of ratings
+// that are close enough widens the longer a player waits, so that nobody waits forever.
+type ratingMatchmaker struct {
+	settings MatchmakingSettings
+}
+
+func (ratingMatchmaker) name() string {
+	return "rating"
+}
+
+func (m ratingMatchmaker) pick(waiting []*player, seated []*player, n int, now time.Time) []*player {
+	var ratings []float64
+	for _, p := range seated {
+		ratings = append(ratings, playerRating(p))
+	}
+
+	var picked []*player
+	for _, p := range waiting {
+		if len(picked) == n {
+			break
+		}
+
+		rating := playerRating(p)
+		// the first player on an empty court is matched with whoever comes next
+		if len(ratings) > 0 && math.Abs(rating-average(ratings)) > m.band(now.Sub(p.waitingSince)) {
+			continue
+		}
+		picked = append(picked, p)
+		ratings = append(ratings, rating)
+	}
+
+	return picked
+}
+
+// band returns how far apart ratings can be for a player who has waited for waited.
+func (m ratingMatchmaker) band(waited time.Duration) float64 {
+	band := float64(m.settings.Band) + float64(m.settings.Widen)*waited.Seconds()
+	if m.settings.MaxBand > 0 {
+		band = math.Min(band, float64(m.settings.MaxBand))
+	}
+	return band
+}
+
+// leastRecentMatchmaker plays whoever last played longest ago, newcomers first.
+type leastRecentMatchmaker struct{}
+
+func (leastRecentMatchmaker) name() string {
+	return "least-recent"
+}
+
+func (leastRecentMatchmaker) pick(waiting []*player, seated []*player, n int, now time.Time) []*player {
+	sorted := append([]*player(nil), waiting...)
+	sort.SliceStable(sorted, func(i, j int) bool { return lastPlayed(sorted[i]).Before(lastPlayed(sorted[j])) })
+
+	if len(sorted) > n {
+		return sorted[:n]
+	}
+	return sorted
+}
+
+// playerRating returns the player's rating, the initial rating if they haven't been rated.
+func playerRating(p *player) float64 {
+	if playerStore != nil && p.id != "" {
+		if r, ok := playerStore.get(p.id); ok {
+			return r.Rating
+		}
+	}
+	return initialRating
+}
+
+// lastPlayed returns when the player last left a court, from their last rated match if they haven't played
+// since connecting. It is zero if they've never played.
+func lastPlayed(p *player) time.Time {
+	if !p.lastPlayed.IsZero() {
+		return p.lastPlayed
+	}
+	if playerStore != nil && p.id != "" {
+		if r, ok := playerStore.get(p.id); ok && r.Rated != 0 {
+			return time.Unix(r.Rated, 0)
+		}
+	}
+	return time.Time{}
+}
+
+func average(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
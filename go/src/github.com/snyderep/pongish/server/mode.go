@@ -0,0 +1,291 @@
+package server
+
+import (
+	"strings"
+	"time"
+)
+
+// seatT is a place on a court for one player.
+type seatT struct {
+	Side sideT `json:"side"`
+	// Lane is which of the side's Lanes the seat's paddle is confined to, lanes split the height of the court.
+	Lane  int `json:"lane"`
+	Lanes int `json:"lanes"`
+	// Team groups seats that win and lose together.
+	Team int `json:"team"`
+	// Screen is which half court the seat's paddle is on, numbered from the left of the whole court. Every player
+	// has a screen of their own, seats with the same Screen each show the same half court, as doubles teammates
+	// do. Classic and ring seats each have a half court to themselves.
+	Screen int `json:"screen"`
+}
+
+// modeT is a way of playing on a court. It lays out the court's seats and decides where the ball goes.
+type modeT interface {
+	// name identifies the mode in config and when creating rooms.
+	name() string
+	// layout returns the court's seats in order.
+	layout() []seatT
+	// refill returns true if empty seats can be filled from the wait list now.
+	refill(c *courtT) bool
+	// ready returns true if there are enough players seated to play.
+	ready(c *courtT) bool
+	// serve picks the seats to put a new ball in play on. They must all be on the same side.
+	serve(c *courtT) []int
+	// pass returns the seats that a ball leaving seat from at angle goes to, and the angle it arrives at.
+	pass(c *courtT, from int, angle int) ([]int, int)
+	// lose returns the seats vacated when seat from lets the ball past and whether that ends the match.
+	lose(c *courtT, from int) ([]int, bool)
+}
+
+// spawnerT is implemented by modes that put more balls in play during a rally.
+type spawnerT interface {
+	// spawn returns true if another ball should be served, with inPlay balls already in play and the last one
+	// served sinceServe ago.
+	spawn(c *courtT, inPlay int, sinceServe time.Duration) bool
+}
+
+// segmentedT is implemented by modes whose court can have display only screens, segments, between the two
+// sides. The ball crosses each segment in turn on its way from one side to the other.
+type segmentedT interface {
+	// maxSegments is the most segments the court can have.
+	maxSegments() int
+}
+
+const (
+	defaultMode = "classic"
+
+	longCourtMaxSegments = 8
+
+	ringSize = 6
+	// ringGatherPeriod is how long a ring waits for more players once it has two, before starting without them.
+	ringGatherPeriod = time.Duration(5) * time.Second
+)
+
+// modes are the available modes by name.
+var modes = map[string]func() modeT{
+	"classic":   func() modeT { return classicMode{} },
+	"doubles":   func() modeT { return doublesMode{} },
+	"ring":      func() modeT { return &ringMode{size: ringSize} },
+	"long":      func() modeT { return longCourtMode{} },
+	"multiball": newMultiBallMode,
+}
+
+// MultiBallSettings are the spawn rules of multiball courts.
+type MultiBallSettings struct {
+	// MaxBalls is the most balls in play at once.
+	MaxBalls int
+	// SpawnPeriod is the number of seconds of rally after each ball is served before another one is.
+	SpawnPeriod int
+}
+
+var multiBall = MultiBallSettings{MaxBalls: 3, SpawnPeriod: 10}
+
+// ConfigureMultiBall sets the spawn rules of multiball courts created from now on.
+func ConfigureMultiBall(settings MultiBallSettings) {
+	multiBall = settings
+}
+
+// newMode returns the named mode, the default mode if name is empty.
+func newMode(name string) (modeT, error) {
+	if name == "" {
+		name = defaultMode
+	}
+
+	newFn, ok := modes[strings.ToLower(name)]
+	if !ok {
+		return nil, ErrUnknownMode
+	}
+
+	return newFn(), nil
+}
+
+// classicMode is one player on each side, the winner stays on and the loser goes to the back of the queue.
+type classicMode struct{}
+
+func (classicMode) name() string {
+	return "classic"
+}
+
+func (classicMode) layout() []seatT {
+	return []seatT{
+		{Side: left, Lanes: 1, Team: 0, Screen: 0},
+		{Side: right, Lanes: 1, Team: 1, Screen: 1},
+	}
+}
+
+func (classicMode) refill(c *courtT) bool {
+	return true
+}
+
+func (classicMode) ready(c *courtT) bool {
+	return c.seated() == len(c.seats)
+}
+
+func (classicMode) serve(c *courtT) []int {
+	// we'll always serve to the left player for now
+	return []int{0}
+}
+
+func (classicMode) pass(c *courtT, from int, angle int) ([]int, int) {
+	return []int{1 - from}, angle
+}
+
+func (classicMode) lose(c *courtT, from int) ([]int, bool) {
+	return []int{from}, true
+}
+
+// doublesMode is two players a side, each with a paddle in their own lane of the side's half of the court.
+// Teammates each have their own screen showing the whole of their half. The ball goes to both teammates and
+// the team wins or loses together.
+type doublesMode struct{}
+
+func (doublesMode) name() string {
+	return "doubles"
+}
+
+func (doublesMode) layout() []seatT {
+	return []seatT{
+		{Side: left, Lane: 0, Lanes: 2, Team: 0, Screen: 0},
+		{Side: left, Lane: 1, Lanes: 2, Team: 0, Screen: 0},
+		{Side: right, Lane: 0, Lanes: 2, Team: 1, Screen: 1},
+		{Side: right, Lane: 1, Lanes: 2, Team: 1, Screen: 1},
+	}
+}
+
+func (doublesMode) refill(c *courtT) bool {
+	return true
+}
+
+func (doublesMode) ready(c *courtT) bool {
+	return c.seated() == len(c.seats)
+}
+
+func (doublesMode) serve(c *courtT) []int {
+	return c.team(0)
+}
+
+func (doublesMode) pass(c *courtT, from int, angle int) ([]int, int) {
+	return c.team(1 - c.layout[from].Team), angle
+}
+
+func (doublesMode) lose(c *courtT, from int) ([]int, bool) {
+	return c.team(c.layout[from].Team), true
+}
+
+// longCourtMode is classic played across a chain of display only screens set up between the two players.
+type longCourtMode struct {
+	classicMode
+}
+
+func (longCourtMode) name() string {
+	return "long"
+}
+
+func (longCourtMode) maxSegments() int {
+	return longCourtMaxSegments
+}
+
+// multiBallMode is classic with more balls served during a rally, each spawnPeriod after the one before, up to
+// maxBalls in play. A player who lets any of them past loses.
+type multiBallMode struct {
+	classicMode
+	maxBalls    int
+	spawnPeriod time.Duration
+}
+
+func newMultiBallMode() modeT {
+	return multiBallMode{maxBalls: multiBall.MaxBalls, spawnPeriod: time.Duration(multiBall.SpawnPeriod) * time.Second}
+}
+
+func (multiBallMode) name() string {
+	return "multiball"
+}
+
+// serve picks a side at random, so the spawned balls come from both ends.
+func (multiBallMode) serve(c *courtT) []int {
+	return []int{c.rnd.Intn(len(c.seats))}
+}
+
+func (m multiBallMode) spawn(c *courtT, inPlay int, sinceServe time.Duration) bool {
+	return inPlay < m.maxBalls && sinceServe >= m.spawnPeriod
+}
+
+// ringMode is up to size players passing the ball around a loop of screens. Everyone plays on the LEFT of
+// their own screen and a ball leaving the right of one screen comes in on the right of the next seated
+// player's. A player who lets the ball past is out and the match goes on until one player remains. Nobody
+// new joins until then.
+type ringMode struct {
+	size        int
+	gatherStart time.Time // when the ring first had enough players to start, zero if it hasn't
+}
+
+func (m *ringMode) name() string {
+	return "ring"
+}
+
+func (m *ringMode) layout() []seatT {
+	seats := make([]seatT, m.size)
+	for i := range seats {
+		seats[i] = seatT{Side: left, Lanes: 1, Team: i, Screen: i}
+	}
+	return seats
+}
+
+func (m *ringMode) refill(c *courtT) bool {
+	return !c.inMatch()
+}
+
+// ready starts a ring as soon as every seat is filled, or ringGatherPeriod after there were first two
+// players, whichever comes first. Once started the ring plays on while it has two players.
+func (m *ringMode) ready(c *courtT) bool {
+	n := c.seated()
+	if n < 2 {
+		m.gatherStart = time.Time{}
+		return false
+	}
+	if c.inMatch() {
+		m.gatherStart = time.Time{}
+		return true
+	}
+	if n == len(c.seats) {
+		return true
+	}
+
+	now := c.clock.Now()
+	if m.gatherStart.IsZero() {
+		m.gatherStart = now
+	}
+	return now.Sub(m.gatherStart) >= ringGatherPeriod
+}
+
+func (m *ringMode) serve(c *courtT) []int {
+	var seated []int
+	for i, p := range c.seats {
+		if p != nil {
+			seated = append(seated, i)
+		}
+	}
+	if len(seated) == 0 {
+		return nil
+	}
+	return []int{seated[c.rnd.Intn(len(seated))]}
+}
+
+// pass sends the ball on to the next seated player around the ring. The ball leaves moving right and must
+// arrive moving left, so the angle is mirrored.
+func (m *ringMode) pass(c *courtT, from int, angle int) ([]int, int) {
+	mirrored := mirrorAngle(angle)
+
+	for i := 1; i < len(c.seats); i++ {
+		next := (from + i) % len(c.seats)
+		if c.seats[next] != nil {
+			return []int{next}, mirrored
+		}
+	}
+
+	return []int{from}, mirrored
+}
+
+func (m *ringMode) lose(c *courtT, from int) ([]int, bool) {
+	return []int{from}, c.seated()-1 <= 1
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// originRejections counts websocket upgrades refused because of their origin since the server started,
+// included in the log line for each rejection.
+var originRejections int64
+
+// originCheckerT decides whether a websocket upgrade request comes from an acceptable origin.
+// Requests from the same host as the server are always accepted, as are requests without an Origin header,
+// which don't come from browsers.
+type originCheckerT struct {
+	origins      map[string]bool // exact origins, e.g. https://portal.example.com:8443
+	hostPatterns []string        // host patterns, e.g. *.example.com
+}
+
+// newOriginChecker creates a checker from allowed entries. An entry containing "://" is an exact origin,
+// anything else is a host pattern as understood by path.Match, matched against the origin's host both with
+// and without its port.
+func newOriginChecker(allowed []string) *originCheckerT {
+	o := &originCheckerT{origins: make(map[string]bool)}
+
+	for _, a := range allowed {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if a == "" {
+			continue
+		}
+		if strings.Contains(a, "://") {
+			o.origins[strings.TrimSuffix(a, "/")] = true
+			continue
+		}
+		if _, err := path.Match(a, ""); err != nil {
+			log.Printf("ignoring malformed allowed origin pattern %q: %s\n", a, err)
+			continue
+		}
+		o.hostPatterns = append(o.hostPatterns, a)
+	}
+
+	return o
+}
+
+func (o *originCheckerT) check(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if o.allowed(origin, r.Host) {
+		return true
+	}
+
+	n := atomic.AddInt64(&originRejections, 1)
+	log.Printf("rejecting websocket upgrade from %s, origin %q not allowed (%d rejected)\n", r.RemoteAddr, origin, n)
+
+	return false
+}
+
+func (o *originCheckerT) allowed(origin string, requestHost string) bool {
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	if u.Host == strings.ToLower(requestHost) {
+		return true
+	}
+
+	if o.origins[u.Scheme+"://"+u.Host] {
+		return true
+	}
+
+	for _, pattern := range o.hostPatterns {
+		for _, host := range []string{u.Host, u.Hostname()} {
+			if ok, _ := path.Match(pattern, host); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
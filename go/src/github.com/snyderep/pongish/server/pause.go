@@ -0,0 +1,147 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// PauseSettings limit how players can pause a match.
+type PauseSettings struct {
+	// MaxPauses is the number of times each player can pause a match, 0 stops players pausing.
+	MaxPauses int
+	// Duration is the number of seconds a pause lasts at most, play resumes by itself afterwards.
+	Duration int
+	// Countdown is the number of seconds between a player asking to resume and play resuming.
+	Countdown int
+}
+
+var pauseSettings = PauseSettings{MaxPauses: 2, Duration: 60, Countdown: 3}
+
+// ConfigurePauses sets how players can pause matches from now on.
+func ConfigurePauses(settings PauseSettings) {
+	pauseSettings = settings
+}
+
+// pauseT is a pause in the current match. Players' screens hold the balls and paddles where they are until
+// play resumes. It is guarded by the court's lock.
+type pauseT struct {
+	seat     int       // who paused
+	until    time.Time // when play resumes by itself
+	resumeAt time.Time // when play resumes after a countdown, zero until somebody asks to resume
+}
+
+// doPauses starts and ends pauses players have asked for.
+func (c *courtT) doPauses() {
+	now := c.clock.Now()
+
+	if !c.inMatch() {
+		c.cancelPause()
+		for _, p := range c.seats {
+			if p != nil {
+				p.pauseRequests()
+			}
+		}
+		return
+	}
+
+	for i, p := range c.seats {
+		if p == nil {
+			continue
+		}
+
+		pause, resume := p.pauseRequests()
+		if pause && c.pause == nil {
+			c.startPause(i, now)
+		} else if resume && c.pause != nil && c.pause.resumeAt.IsZero() {
+			c.startCountdown(now)
+		}
+	}
+
+	if c.pause == nil {
+		return
+	}
+	if c.pause.resumeAt.IsZero() && !now.Before(c.pause.until) {
+		c.startCountdown(now)
+	}
+	if !c.pause.resumeAt.IsZero() && !now.Before(c.pause.resumeAt) {
+		log.Printf("play resumed on seat %d's pause\n", c.pause.seat)
+		c.record(matchEvent{Kind: eventResume, Seat: c.pause.seat})
+		c.pause = nil
+	}
+}
+
+// startPause pauses the match for a seat if they have any pauses left.
+func (c *courtT) startPause(seat int, now time.Time) {
+	if c.pausesUsed[seat] >= pauseSettings.MaxPauses {
+		log.Printf("seat %d has no pauses left\n", seat)
+		return
+	}
+	c.pausesUsed[seat]++
+
+	duration := time.Duration(pauseSettings.Duration) * time.Second
+	c.pause = &pauseT{seat: seat, until: now.Add(duration)}
+
+	log.Printf("seat %d paused the match, %d of %d pauses\n", seat, c.pausesUsed[seat], pauseSettings.MaxPauses)
+	c.record(matchEvent{Kind: eventPause, Seat: seat, Side: c.layout[seat].Side})
+	c.tellAll(fmt.Sprintf("Z,%s,%d,%d", c.layout[seat].Side, pauseSettings.MaxPauses-c.pausesUsed[seat],
+		int64(duration/time.Millisecond)))
+}
+
+// startCountdown starts counting down to the end of the pause.
+func (c *courtT) startCountdown(now time.Time) {
+	countdown := time.Duration(pauseSettings.Countdown) * time.Second
+	c.pause.resumeAt = now.Add(countdown)
+	c.tellAll(fmt.Sprintf("R,%d", int64(countdown/time.Millisecond)))
+}
+
+// cancelPause ends any pause straight away, the match it was in is over.
+func (c *courtT) cancelPause() {
+	if c.pause != nil {
+		c.pause = nil
+		c.tellAll("R,0")
+	}
+}
+
+// paused returns true if the match is paused, including while counting down to resume.
+func (c *courtT) paused() bool {
+	return c.pause != nil
+}
+
+// tellAll sends a message to everyone on the court, players and displays.
+func (c *courtT) tellAll(msg string) {
+	for _, p := range c.seats {
+		if p != nil {
+			p.queue(msg)
+		}
+	}
+	for _, d := range c.segments {
+		d.queue(msg)
+	}
+}
+
+// requestPause asks for the match to be paused.
+func (p *player) requestPause() {
+	p.requestLock.Lock()
+	defer p.requestLock.Unlock()
+
+	p.pauseRequested = true
+}
+
+// requestResume asks for a paused match to resume.
+func (p *player) requestResume() {
+	p.requestLock.Lock()
+	defer p.requestLock.Unlock()
+
+	p.resumeRequested = true
+}
+
+// pauseRequests returns and forgets whether the player has asked to pause or resume the match.
+func (p *player) pauseRequests() (bool, bool) {
+	p.requestLock.Lock()
+	defer p.requestLock.Unlock()
+
+	pause, resume := p.pauseRequested, p.resumeRequested
+	p.pauseRequested, p.resumeRequested = false, false
+	return pause, resume
+}
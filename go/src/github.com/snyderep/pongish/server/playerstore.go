@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/rs/xid"
+)
+
+// playerRecordT is what's kept about a player between visits, keyed by the id in their session cookie.
+type playerRecordT struct {
+	ID        string          `json:"id"`
+	Rating    float64         `json:"rating"`
+	RD        float64         `json:"rd"` // the rating deviation, how unsure the rating is
+	Matches   int             `json:"matches"`
+	Rated     int64           `json:"rated"` // when the rating last changed, unix seconds
+	History   []ratingChangeT `json:"history,omitempty"`
+	Stats     playerStatsT    `json:"stats"`
+	Recent    []matchSummaryT `json:"recent,omitempty"`    // the player's last matches, oldest first
+	Opponents map[string]int  `json:"opponents,omitempty"` // the number of matches against each opponent
+	Badges    []badgeT        `json:"badges,omitempty"`    // the achievements the player has earned, in order
+}
+
+// playerStoreT keeps player records in a JSON file, rewritten whenever a record changes.
+type playerStoreT struct {
+	lock    sync.Mutex
+	path    string
+	players map[string]*playerRecordT
+}
+
+// playerStore is nil unless EnablePlayerStore has been called.
+var playerStore *playerStoreT
+
+// saving tracks the match results being saved to the player store in the background. The store is only
+// replaced once they're saved, so none of them end up in the wrong store.
+var saving sync.WaitGroup
+
+// EnablePlayerStore starts keeping player records, including ratings, in the file at path. Records already in
+// the file are loaded.
+func EnablePlayerStore(path string) error {
+	s := &playerStoreT{path: path, players: make(map[string]*playerRecordT)}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(data) > 0 {
+		var records []*playerRecordT
+		if err := json.Unmarshal(data, &records); err != nil {
+			return err
+		}
+		for _, r := range records {
+			s.players[r.ID] = r
+		}
+	}
+
+	saving.Wait()
+	playerStore = s
+
+	return nil
+}
+
+// validPlayerID returns true if id could be the id of a player, they're the xids minted by the screen handler.
+func validPlayerID(id string) bool {
+	var x xid.ID
+	return x.UnmarshalText([]byte(id)) == nil
+}
+
+// get returns a copy of a player's record, false if there isn't one.
+func (s *playerStoreT) get(id string) (playerRecordT, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	r, ok := s.players[id]
+	if !ok {
+		return playerRecordT{}, false
+	}
+	return r.clone(), true
+}
+
+// update changes the records of players one at a time, creating any that don't exist yet, and saves the store.
+func (s *playerStoreT) update(ids []string, change func(r *playerRecordT)) error {
+	return s.updateAll(ids, func(records map[string]*playerRecordT) {
+		for _, id := range ids {
+			change(records[id])
+		}
+	})
+}
+
+// updateAll changes the records of players together, creating any that don't exist yet, and saves the store.
+// change is given every record at once, keyed by id, for changes that depend on the other records.
+func (s *playerStoreT) updateAll(ids []string, change func(records map[string]*playerRecordT)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	records := make(map[string]*playerRecordT, len(ids))
+	for _, id := range ids {
+		r, ok := s.players[id]
+		if !ok {
+			r = newPlayerRecord(id)
+			s.players[id] = r
+		}
+		records[id] = r
+	}
+	change(records)
+
+	return s.save()
+}
+
+// all returns copies of every player's record.
+func (s *playerStoreT) all() []playerRecordT {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	records := make([]playerRecordT, 0, len(s.players))
+	for _, r := range s.players {
+		records = append(records, r.clone())
+	}
+	return records
+}
+
+// clone returns a copy of the record that shares nothing with it, so it can be read without the lock.
+func (r *playerRecordT) clone() playerRecordT {
+	c := *r
+	c.History = append([]ratingChangeT(nil), r.History...)
+	c.Recent = append([]matchSummaryT(nil), r.Recent...)
+	c.Badges = append([]badgeT(nil), r.Badges...)
+	if r.Opponents != nil {
+		c.Opponents = make(map[string]int, len(r.Opponents))
+		for id, n := range r.Opponents {
+			c.Opponents[id] = n
+		}
+	}
+	return c
+}
+
+// save writes the store to a new file and moves it over the old one, so that a crash can't leave half a file.
+// The lock must be held.
+func (s *playerStoreT) save() error {
+	records := make([]*playerRecordT, 0, len(s.players))
+	for _, r := range s.players {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
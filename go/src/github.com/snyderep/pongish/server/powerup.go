@@ -0,0 +1,272 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// power-up kinds
+const (
+	powerUpGrow   = "grow"   // the collector's paddle is bigger for a while
+	powerUpShrink = "shrink" // the opponents' paddles are smaller for a while
+	powerUpFast   = "fast"   // the ball that collected it speeds up when it next crosses the net
+	powerUpSplit  = "split"  // the ball that collected it splits in two when it next crosses the net
+	powerUpShield = "shield" // the collector's end wall returns the ball for a while
+)
+
+var powerUpKinds = []string{powerUpGrow, powerUpShrink, powerUpFast, powerUpSplit, powerUpShield}
+
+const (
+	// how much faster a fast ball goes
+	powerUpFastBoost = 3
+	// power-ups appear away from the ends of a half court so that paddles don't collect them
+	powerUpMinX = 200
+	powerUpMaxX = 1100
+	powerUpMinY = 100
+	powerUpMaxY = 900
+)
+
+// PowerUpSettings are the timings of power-ups on courts that have them.
+type PowerUpSettings struct {
+	// Period is the number of seconds between power-ups appearing on a court.
+	Period int
+	// Lifetime is the number of seconds a power-up stays on the court if it isn't collected.
+	Lifetime int
+	// Duration is the number of seconds paddle and shield effects last.
+	Duration int
+}
+
+var powerUpSettings = PowerUpSettings{Period: 8, Lifetime: 10, Duration: 10}
+
+// ConfigurePowerUps sets the timings of power-ups on courts created from now on.
+func ConfigurePowerUps(settings PowerUpSettings) {
+	powerUpSettings = settings
+}
+
+// powerUpT is an item on one player's half of the court, collected by hitting it with a ball.
+type powerUpT struct {
+	id      int
+	kind    string
+	seat    int
+	player  *player // the power-up goes when the seat's player does
+	x       int
+	y       int
+	expires time.Time
+}
+
+// powerUpsT are the power-ups on a court. The court decides when and where they appear and what they do, so
+// that every screen agrees. It is guarded by the court's lock.
+type powerUpsT struct {
+	period    time.Duration
+	lifetime  time.Duration
+	duration  time.Duration
+	next      int // the id of the next power-up
+	items     map[int]*powerUpT
+	lastSpawn time.Time
+	fast      map[int]bool // balls to speed up at their next hand-off
+	split     map[int]bool // balls to split at their next hand-off
+}
+
+// enablePowerUps turns on power-ups for the court with the current settings.
+func (c *courtT) enablePowerUps() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.powerUps = &powerUpsT{
+		period:    time.Duration(powerUpSettings.Period) * time.Second,
+		lifetime:  time.Duration(powerUpSettings.Lifetime) * time.Second,
+		duration:  time.Duration(powerUpSettings.Duration) * time.Second,
+		items:     make(map[int]*powerUpT),
+		lastSpawn: c.clock.Now(),
+		fast:      make(map[int]bool),
+		split:     make(map[int]bool),
+	}
+}
+
+// hasPowerUps returns true if power-ups appear on the court.
+func (c *courtT) hasPowerUps() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.powerUps != nil
+}
+
+// doPowerUps applies the effects of collected power-ups, takes away expired ones and spawns new ones.
+func (c *courtT) doPowerUps() {
+	pu := c.powerUps
+	if pu == nil {
+		return
+	}
+
+	now := c.clock.Now()
+	ready := c.mode.ready(c)
+
+	for _, p := range c.seats {
+		if p == nil {
+			continue
+		}
+		for id, ball := range p.collections() {
+			if item, ok := pu.items[id]; ok && item.player == p && ready {
+				c.collect(item, ball)
+			}
+		}
+	}
+
+	for id, item := range pu.items {
+		if !ready || c.seats[item.seat] != item.player {
+			// the player has gone, and the power-up with them
+			delete(pu.items, id)
+		} else if now.After(item.expires) {
+			c.removePowerUp(item)
+		}
+	}
+
+	if !ready {
+		pu.lastSpawn = now
+		return
+	}
+	if now.Sub(pu.lastSpawn) >= pu.period {
+		pu.lastSpawn = now
+		c.spawnPowerUp(now)
+	}
+}
+
+// spawnPowerUp puts a random power-up on a random seated player's half of the court.
+func (c *courtT) spawnPowerUp(now time.Time) {
+	var seated []int
+	for i, p := range c.seats {
+		if p != nil {
+			seated = append(seated, i)
+		}
+	}
+	if len(seated) == 0 {
+		return
+	}
+
+	pu := c.powerUps
+	seat := seated[c.rnd.Intn(len(seated))]
+	item := &powerUpT{
+		id:      pu.next,
+		kind:    powerUpKinds[c.rnd.Intn(len(powerUpKinds))],
+		seat:    seat,
+		player:  c.seats[seat],
+		x:       powerUpMinX + c.rnd.Intn(powerUpMaxX-powerUpMinX),
+		y:       powerUpMinY + c.rnd.Intn(powerUpMaxY-powerUpMinY),
+		expires: now.Add(pu.lifetime),
+	}
+	pu.next++
+	pu.items[item.id] = item
+
+	log.Printf("power-up %d (%s) appeared on seat %d\n", item.id, item.kind, seat)
+	c.record(matchEvent{Kind: eventPowerUp, Seat: seat, Side: c.layout[seat].Side, Item: item.kind, X: item.x, Y: item.y})
+
+	for _, p := range c.sameScreen(seat) {
+		p.sendPowerUpMsg(item)
+	}
+}
+
+// collect applies the effect of a power-up collected by a ball.
+func (c *courtT) collect(item *powerUpT, ball int) {
+	pu := c.powerUps
+
+	log.Printf("power-up %d (%s) collected on seat %d by ball %d\n", item.id, item.kind, item.seat, ball)
+	c.record(matchEvent{Kind: eventCollect, Seat: item.seat, Side: c.layout[item.seat].Side, Item: item.kind, Ball: ball})
+	c.removePowerUp(item)
+
+	switch item.kind {
+	case powerUpGrow:
+		item.player.sendEffectMsg(item.kind, pu.duration)
+	case powerUpShield:
+		for _, p := range c.sameScreen(item.seat) {
+			p.sendEffectMsg(item.kind, pu.duration)
+		}
+	case powerUpShrink:
+		for i, p := range c.seats {
+			if p != nil && c.layout[i].Team != c.layout[item.seat].Team {
+				p.sendEffectMsg(item.kind, pu.duration)
+			}
+		}
+	case powerUpFast:
+		pu.fast[ball] = true
+	case powerUpSplit:
+		pu.split[ball] = true
+	}
+}
+
+// removePowerUp takes a power-up off the court.
+func (c *courtT) removePowerUp(item *powerUpT) {
+	delete(c.powerUps.items, item.id)
+
+	for _, p := range c.sameScreen(item.seat) {
+		p.sendPowerUpGoneMsg(item.id)
+	}
+}
+
+// handOffEffects applies the effects of power-ups to a ball crossing the net. It returns the ball's new speed
+// and the id of a new ball split from it, -1 if it didn't split.
+func (c *courtT) handOffEffects(id int, speed int) (int, int) {
+	pu := c.powerUps
+	if pu == nil {
+		return speed, -1
+	}
+
+	if pu.fast[id] {
+		delete(pu.fast, id)
+		speed += powerUpFastBoost
+	}
+
+	split := -1
+	if pu.split[id] {
+		delete(pu.split, id)
+		split = c.nextBall
+		c.nextBall++
+	}
+
+	return speed, split
+}
+
+// sameScreen returns the players seated on the same screen as a seat, including its own.
+func (c *courtT) sameScreen(seat int) []*player {
+	var players []*player
+	for i, p := range c.seats {
+		if p != nil && c.layout[i].Screen == c.layout[seat].Screen {
+			players = append(players, p)
+		}
+	}
+	return players
+}
+
+// collectPowerUp keeps a power-up the player's ball hit until the court applies it.
+func (p *player) collectPowerUp(id int, ball int) {
+	p.ballLock.Lock()
+	defer p.ballLock.Unlock()
+
+	p.collected[id] = ball
+}
+
+// collections returns and forgets the power-ups the player has collected, the ids of the balls that collected
+// them by power-up id.
+func (p *player) collections() map[int]int {
+	p.ballLock.Lock()
+	defer p.ballLock.Unlock()
+
+	collected := p.collected
+	p.collected = make(map[int]int)
+	return collected
+}
+
+// sendPowerUpMsg tells a player a power-up has appeared on their half of the court.
+func (p *player) sendPowerUpMsg(item *powerUpT) {
+	p.queue(fmt.Sprintf("U,%d,%s,%d,%d", item.id, item.kind, item.x, item.y))
+}
+
+// sendPowerUpGoneMsg tells a player a power-up is no longer on their half of the court.
+func (p *player) sendPowerUpGoneMsg(id int) {
+	p.queue(fmt.Sprintf("X,%d", id))
+}
+
+// sendEffectMsg tells a player a power-up's effect applies to them for a while.
+func (p *player) sendEffectMsg(kind string, duration time.Duration) {
+	p.queue(fmt.Sprintf("E,%s,%d", kind, int64(duration/time.Millisecond)))
+}
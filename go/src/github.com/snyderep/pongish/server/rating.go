@@ -0,0 +1,169 @@
+package server
+
+import (
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+// Ratings are Glicko ratings, see http://www.glicko.net/glicko/glicko.pdf. Each match is a rating period of
+// its own, so a player's rating changes as soon as a match they played in is over.
+const (
+	initialRating = 1500.0
+	initialRD     = 350.0
+	minRD         = 30.0
+	// how fast the rating deviation grows back towards initialRD while a player doesn't play, per day
+	rdGrowth = 34.6
+	// a rating is provisional, listed after the established ratings on the leaderboard, until its deviation
+	// falls below this
+	provisionalRD = 110.0
+	// the most rating changes kept for each player
+	maxRatingHistory = 200
+)
+
+var glickoQ = math.Ln10 / 400
+
+// ratingChangeT is a player's rating after a match.
+type ratingChangeT struct {
+	Match  string  `json:"match"`
+	T      int64   `json:"t"` // unix seconds
+	Rating float64 `json:"rating"`
+	RD     float64 `json:"rd"`
+	Won    bool    `json:"won"`
+}
+
+func newPlayerRecord(id string) *playerRecordT {
+	return &playerRecordT{ID: id, Rating: initialRating, RD: initialRD}
+}
+
+// provisional returns true if there isn't enough known about the player for their rating to mean much.
+func (r *playerRecordT) provisional() bool {
+	return r.RD >= provisionalRD
+}
+
+// currentRD returns the player's rating deviation at now, grown since they last played.
+func (r *playerRecordT) currentRD(now time.Time) float64 {
+	if r.Rated == 0 {
+		return r.RD
+	}
+	days := now.Sub(time.Unix(r.Rated, 0)).Hours() / 24
+	return math.Min(math.Sqrt(r.RD*r.RD+rdGrowth*rdGrowth*days), initialRD)
+}
+
+// rateMatch updates the ratings of the players in a match that the winners won. Each team plays as one
+// player with the average rating of its players. Players without ids aren't rated.
+func rateMatch(matchID string, now time.Time, winners []*player, losers []*player) {
+	if playerStore == nil {
+		return
+	}
+
+	winnerIDs, loserIDs := playerIDs(winners), playerIDs(losers)
+	if len(winnerIDs) == 0 || len(loserIDs) == 0 {
+		return
+	}
+
+	// a player on both teams (one browser playing itself) isn't rated
+	ids := append(append([]string(nil), winnerIDs...), loserIDs...)
+	won := make(map[string]bool)
+	for i, id := range ids {
+		if _, ok := won[id]; ok {
+			log.Printf("not rating match %s, player %s is on both sides\n", matchID, id)
+			return
+		}
+		won[id] = i < len(winnerIDs)
+	}
+
+	// the ratings are read and changed together, so a match finishing at the same time can't change them between
+	err := playerStore.updateAll(ids, func(records map[string]*playerRecordT) {
+		// the teams as they were before the match
+		before := make(map[string]playerRecordT)
+		for id, r := range records {
+			before[id] = playerRecordT{Rating: r.Rating, RD: r.currentRD(now)}
+		}
+		winRating, winRD := teamRating(winnerIDs, before)
+		loseRating, loseRD := teamRating(loserIDs, before)
+
+		for _, id := range ids {
+			r, b := records[id], before[id]
+			if won[id] {
+				r.Rating, r.RD = glicko(b.Rating, b.RD, loseRating, loseRD, 1)
+			} else {
+				r.Rating, r.RD = glicko(b.Rating, b.RD, winRating, winRD, 0)
+			}
+			r.Matches++
+			r.Rated = now.Unix()
+
+			r.History = append(r.History, ratingChangeT{Match: matchID, T: r.Rated, Rating: r.Rating, RD: r.RD,
+				Won: won[id]})
+			if len(r.History) > maxRatingHistory {
+				r.History = r.History[len(r.History)-maxRatingHistory:]
+			}
+		}
+	})
+	if err != nil {
+		log.Printf("error saving ratings for match %s: %s\n", matchID, err)
+	}
+}
+
+// playerIDs returns the ids of the players that have them.
+func playerIDs(players []*player) []string {
+	var ids []string
+	for _, p := range players {
+		if p != nil && p.id != "" {
+			ids = append(ids, p.id)
+		}
+	}
+	return ids
+}
+
+// teamRating returns the average rating and deviation of a team.
+func teamRating(ids []string, records map[string]playerRecordT) (float64, float64) {
+	var rating, rd float64
+	for _, id := range ids {
+		rating += records[id].Rating
+		rd += records[id].RD
+	}
+	n := float64(len(ids))
+	return rating / n, rd / n
+}
+
+// glicko returns a player's new rating and deviation after a game against an opponent, score is 1 for a win
+// and 0 for a loss.
+func glicko(rating float64, rd float64, oppRating float64, oppRD float64, score float64) (float64, float64) {
+	g := 1 / math.Sqrt(1+3*glickoQ*glickoQ*oppRD*oppRD/(math.Pi*math.Pi))
+	expected := 1 / (1 + math.Pow(10, -g*(rating-oppRating)/400))
+	dSquared := 1 / (glickoQ * glickoQ * g * g * expected * (1 - expected))
+
+	precision := 1/(rd*rd) + 1/dSquared
+	newRating := rating + glickoQ/precision*g*(score-expected)
+	newRD := math.Max(math.Sqrt(1/precision), minRD)
+
+	return newRating, newRD
+}
+
+// leaderboard returns the rated players, highest rated first, at most n of them. Players with provisional
+// ratings come after everyone with an established rating.
+func leaderboard(n int) []playerRecordT {
+	if playerStore == nil {
+		return nil
+	}
+
+	var rated []playerRecordT
+	for _, r := range playerStore.all() {
+		if r.Matches > 0 {
+			rated = append(rated, r)
+		}
+	}
+	sort.Slice(rated, func(i, j int) bool {
+		if rated[i].provisional() != rated[j].provisional() {
+			return !rated[i].provisional()
+		}
+		return rated[i].Rating > rated[j].Rating
+	})
+
+	if len(rated) > n {
+		rated = rated[:n]
+	}
+	return rated
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateMatchConcurrently(t *testing.T) {
+	useTestPlayerStore(t)
+
+	// one player wins matches against new players that all finish at once, each win has to build on the last
+	const matches = 20
+	winner := &player{id: "winner"}
+	now := time.Date(2026, 1, 5, 12, 0, 0, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	for i := 0; i < matches; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rateMatch(fmt.Sprint("match-", i), now, []*player{winner}, []*player{{id: fmt.Sprint("loser-", i)}})
+		}(i)
+	}
+	wg.Wait()
+
+	r, _ := playerStore.get(winner.id)
+	if r.Matches != matches || len(r.History) != matches {
+		t.Fatalf("rated %d times with %d changes, want %d", r.Matches, len(r.History), matches)
+	}
+	for i := 1; i < len(r.History); i++ {
+		if r.History[i].Rating <= r.History[i-1].Rating {
+			t.Errorf("rating %.1f after win %d not above %.1f", r.History[i].Rating, i, r.History[i-1].Rating)
+		}
+	}
+}
+
+func TestLeaderboardListsProvisionalLast(t *testing.T) {
+	useTestPlayerStore(t)
+
+	records := map[string]playerRecordT{
+		"settled-low":       {Rating: 1400, RD: 50, Matches: 30},
+		"settled-high":      {Rating: 1600, RD: 50, Matches: 30},
+		"provisional-high":  {Rating: 1800, RD: 200, Matches: 2},
+		"provisional-lower": {Rating: 1700, RD: 200, Matches: 2},
+		"never-rated":       {Rating: initialRating, RD: initialRD},
+	}
+	var ids []string
+	for id := range records {
+		ids = append(ids, id)
+	}
+	if err := playerStore.update(ids, func(r *playerRecordT) {
+		want := records[r.ID]
+		r.Rating, r.RD, r.Matches = want.Rating, want.RD, want.Matches
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, r := range leaderboard(10) {
+		got = append(got, r.ID)
+	}
+	want := []string{"settled-high", "settled-low", "provisional-high", "provisional-lower"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("leaderboard = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/xid"
+)
+
+// recordStoreT keeps match recordings as one file of JSON lines per match, named after the match id.
+type recordStoreT struct {
+	root string
+}
+
+// recordStore is nil unless EnableRecording has been called.
+var recordStore *recordStoreT
+
+// EnableRecording starts recording every match to a file under root, creating root if necessary.
+func EnableRecording(root string) error {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+
+	recordStore = &recordStoreT{root: root}
+
+	return nil
+}
+
+func (s *recordStoreT) path(matchID string) (string, error) {
+	// match ids are xids, checking that keeps anything else, like ../, out of file names
+	var id xid.ID
+	if err := id.UnmarshalText([]byte(matchID)); err != nil {
+		return "", ErrUnknownMatch
+	}
+
+	return filepath.Join(s.root, matchID+".jsonl"), nil
+}
+
+func (s *recordStoreT) create(matchID string) (io.WriteCloser, error) {
+	p, err := s.path(matchID)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+}
+
+// load reads back all the events recorded for a match.
+func (s *recordStoreT) load(matchID string) ([]matchEvent, error) {
+	p, err := s.path(matchID)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrUnknownMatch
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []matchEvent
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e matchEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// a partly written last line, the server probably stopped mid match
+			break
+		}
+		events = append(events, e)
+	}
+
+	return events, scanner.Err()
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// RematchSettings are how long players have to agree to a rematch.
+type RematchSettings struct {
+	// Timeout is the number of seconds everyone in a match has to accept a rematch, 0 never offers one.
+	Timeout int
+}
+
+var rematchSettings = RematchSettings{Timeout: 15}
+
+// ConfigureRematches sets how rematches are offered from now on.
+func ConfigureRematches(settings RematchSettings) {
+	rematchSettings = settings
+}
+
+// rematch answers
+const (
+	rematchUnanswered = iota
+	rematchAccepted
+	rematchDeclined
+)
+
+// rematchT is a rematch offered to everyone who was on the court at the end of a match. The losers are kept off
+// the wait list, and nobody else is seated, until everyone has answered or the offer runs out. It is guarded
+// by the court's lock.
+type rematchT struct {
+	lineup   []*player // indexed like the court's seats, nil for seats that were empty
+	deadline time.Time
+}
+
+// rematchAllowed returns true if the players at the end of a match can stay on the court for another. They
+// can't while anyone is waiting to play.
+func (c *courtT) rematchAllowed() bool {
+	return rematchSettings.Timeout > 0 && c.waiters.Len() == 0
+}
+
+// offerRematch offers everyone in lineup, who were on the court at the end of the match, a rematch.
+func (c *courtT) offerRematch(lineup []*player) {
+	timeout := time.Duration(rematchSettings.Timeout) * time.Second
+	c.rematch = &rematchT{lineup: lineup, deadline: c.clock.Now().Add(timeout)}
+
+	log.Println("offering a rematch")
+	for _, p := range lineup {
+		if p != nil {
+			p.answerRematch(rematchUnanswered)
+			p.sendRematchMsg(timeout)
+		}
+	}
+}
+
+// doRematch starts the rematch once everyone has accepted it, and withdraws it if anyone declines, leaves or
+// doesn't answer in time. It is also withdrawn if anyone has joined the wait list since it was offered, so they
+// don't wait behind a rematch.
+func (c *courtT) doRematch() {
+	rm := c.rematch
+	if rm == nil {
+		return
+	}
+
+	if !c.rematchAllowed() {
+		c.withdrawRematch("others waiting")
+		return
+	}
+
+	accepted := true
+	for _, p := range rm.lineup {
+		if p == nil {
+			continue
+		}
+		answer := p.rematchAnswer()
+		if p.getState() == dead || answer == rematchDeclined {
+			c.withdrawRematch("declined")
+			return
+		}
+		if answer != rematchAccepted {
+			accepted = false
+		}
+	}
+
+	if !accepted {
+		if !c.clock.Now().Before(rm.deadline) {
+			c.withdrawRematch("not answered")
+		}
+		return
+	}
+
+	log.Println("rematch accepted")
+	c.rematch = nil
+	for i, p := range rm.lineup {
+		if p != nil {
+			c.seats[i] = p
+			p.play(i, c.layout[i])
+		}
+	}
+}
+
+// withdrawRematch puts the losers on the wait list after all.
+func (c *courtT) withdrawRematch(reason string) {
+	log.Printf("rematch withdrawn: %s\n", reason)
+
+	lineup := c.rematch.lineup
+	c.rematch = nil
+
+	for i, p := range lineup {
+		if p == nil {
+			continue
+		}
+		if p.getState() == dead {
+			if c.seats[i] != p {
+				p.wsConn.Close()
+			}
+			continue
+		}
+		p.sendRematchMsg(0)
+		if c.seats[i] == p {
+			continue
+		}
+		if err := c.waiters.Add(p); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// answerRematch keeps the player's answer to a rematch offer until the court looks at it.
+func (p *player) answerRematch(answer int) {
+	p.requestLock.Lock()
+	defer p.requestLock.Unlock()
+
+	p.rematch = answer
+}
+
+// rematchAnswer returns the player's answer to the rematch offer.
+func (p *player) rematchAnswer() int {
+	p.requestLock.Lock()
+	defer p.requestLock.Unlock()
+
+	return p.rematch
+}
+
+// sendRematchMsg offers the player a rematch they have timeout to accept, a timeout of 0 withdraws the offer.
+func (p *player) sendRematchMsg(timeout time.Duration) {
+	p.queue(fmt.Sprintf("Q,%d", int64(timeout/time.Millisecond)))
+}
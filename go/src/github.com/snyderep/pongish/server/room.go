@@ -0,0 +1,212 @@
+package server
+
+import (
+	"crypto/rand"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// characters that can't be confused with each other when read aloud or off a screen
+	roomCodeAlphabet   = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	roomCodeLength     = 6
+	roomMaxWaiting     = 8
+	roomMaxCount       = 1024
+	roomIdleTimeout    = time.Duration(10) * time.Minute
+	roomReapPeriod     = time.Duration(30) * time.Second
+	roomCodeMaxRetries = 10
+)
+
+// room is a private court that can only be joined by knowing its code.
+type room struct {
+	code     string
+	court    *courtT
+	lastBusy time.Time
+	// who created the room, the session is empty if they didn't have one
+	creatorIP      string
+	creatorSession string
+}
+
+type roomsT struct {
+	lock  sync.RWMutex
+	rooms map[string]*room
+	clock clock
+}
+
+var rooms = newRooms(systemClock{})
+
+// newRooms creates the rooms, which with their courts take their time from clk. Idle rooms are closed every
+// roomReapPeriod according to clk.
+func newRooms(clk clock) *roomsT {
+	r := &roomsT{rooms: make(map[string]*room), clock: clk}
+
+	go func() {
+		for {
+			<-clk.After(roomReapPeriod)
+			r.reapIdle()
+		}
+	}()
+
+	return r
+}
+
+// roomOptions are how a private room is played.
+type roomOptions struct {
+	mode       string // the name of the mode, empty for the default
+	powerUps   bool
+	board      string // the name of the board, empty for an empty court
+	theme      string // the name of the colour theme, empty for the client's default
+	matchmaker string // the name of the matchmaker, empty for the default
+	rotation   string // the name of the rotation, empty for the default
+}
+
+// create creates a new private room for a creator at ip with session and returns its code. Each creator can
+// only have as many rooms open at once as limits allow.
+func (r *roomsT) create(opts roomOptions, ip string, session string, limits Limits) (string, error) {
+	mode, err := newMode(opts.mode)
+	if err != nil {
+		return "", err
+	}
+	if _, err := findBoard(opts.board); err != nil {
+		return "", err
+	}
+	theme, err := findTheme(opts.theme)
+	if err != nil {
+		return "", err
+	}
+	matchmaker, err := newMatchmaker(opts.matchmaker)
+	if err != nil {
+		return "", err
+	}
+	rotation, err := newRotation(opts.rotation)
+	if err != nil {
+		return "", err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if len(r.rooms) >= roomMaxCount {
+		return "", ErrTooManyRooms
+	}
+	if err := r.checkCreator(ip, session, limits); err != nil {
+		return "", err
+	}
+
+	for i := 0; i < roomCodeMaxRetries; i++ {
+		code, err := newRoomCode()
+		if err != nil {
+			return "", err
+		}
+		if _, exists := r.rooms[code]; exists {
+			continue
+		}
+
+		crt := newCourtWith(roomMaxWaiting, mode, r.clock, newRand())
+		if opts.powerUps {
+			crt.enablePowerUps()
+		}
+		if opts.board != "" {
+			crt.boards = []string{opts.board}
+		}
+		crt.theme = theme
+		crt.matchmaker = matchmaker
+		crt.rotation = rotation
+		r.rooms[code] = &room{code: code, court: crt, lastBusy: r.clock.Now(), creatorIP: ip, creatorSession: session}
+		log.Printf("created private %s room %s, power-ups: %t, board: %q, theme: %q, matchmaker: %s, rotation: %s\n",
+			mode.name(), code, opts.powerUps, opts.board, theme, matchmaker.name(), rotation.name())
+
+		return code, nil
+	}
+
+	return "", ErrTooManyRooms
+}
+
+// checkCreator returns an error if the creator at ip with session already has as many open rooms as limits
+// allow. The lock must be held.
+func (r *roomsT) checkCreator(ip string, session string, limits Limits) error {
+	byIP, bySession := 0, 0
+	for _, rm := range r.rooms {
+		if rm.creatorIP == ip {
+			byIP++
+		}
+		if session != "" && rm.creatorSession == session {
+			bySession++
+		}
+	}
+
+	if limits.MaxRoomsPerIP > 0 && byIP >= limits.MaxRoomsPerIP {
+		return ErrTooManyRoomsCreated
+	}
+	if limits.MaxRoomsPerSession > 0 && bySession >= limits.MaxRoomsPerSession {
+		return ErrTooManyRoomsCreated
+	}
+	return nil
+}
+
+// join calls add with the court of the room with the given code. The room can't be closed as idle until add
+// returns, so whoever add puts on the court isn't left on a closed one.
+func (r *roomsT) join(code string, add func(c *courtT) error) error {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	rm, ok := r.rooms[normalizeRoomCode(code)]
+	if !ok {
+		return ErrUnknownRoom
+	}
+
+	return add(rm.court)
+}
+
+// court returns the court for the room with the given code. Codes are case insensitive.
+func (r *roomsT) court(code string) (*courtT, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	rm, ok := r.rooms[normalizeRoomCode(code)]
+	if !ok {
+		return nil, ErrUnknownRoom
+	}
+
+	return rm.court, nil
+}
+
+// reapIdle closes rooms that have had nobody in them for roomIdleTimeout.
+func (r *roomsT) reapIdle() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.clock.Now()
+
+	for code, rm := range r.rooms {
+		if !rm.court.empty() {
+			rm.lastBusy = now
+			continue
+		}
+		if now.Sub(rm.lastBusy) > roomIdleTimeout {
+			log.Printf("closing idle private room %s\n", code)
+			rm.court.close()
+			delete(r.rooms, code)
+		}
+	}
+}
+
+func newRoomCode() (string, error) {
+	buf := make([]byte, roomCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	code := make([]byte, roomCodeLength)
+	for i, b := range buf {
+		code[i] = roomCodeAlphabet[int(b)%len(roomCodeAlphabet)]
+	}
+
+	return string(code), nil
+}
+
+func normalizeRoomCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoomsReapIdle(t *testing.T) {
+	tests := []struct {
+		name     string
+		idle     time.Duration
+		occupied bool
+		wantOpen bool
+	}{
+		{name: "recently created", idle: time.Minute, wantOpen: true},
+		{name: "idle too long", idle: roomIdleTimeout + time.Second, wantOpen: false},
+		{name: "someone waiting", idle: roomIdleTimeout + time.Second, occupied: true, wantOpen: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := newFakeClock(time.Date(2026, 1, 5, 12, 0, 0, 0, time.UTC))
+			r := newRooms(clk)
+			code, err := r.create(roomOptions{}, "10.0.0.1", "", testLimits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := r.court(code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.close()
+			if tt.occupied {
+				joinTestPlayers(t, c, 1)
+			}
+
+			clk.advance(tt.idle)
+			r.reapIdle()
+
+			_, err = r.court(code)
+			if open := err == nil; open != tt.wantOpen {
+				t.Errorf("room open = %t, want %t", open, tt.wantOpen)
+			}
+		})
+	}
+}
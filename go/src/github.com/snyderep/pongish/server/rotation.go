@@ -0,0 +1,264 @@
+package server
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// rotationT decides who leaves the court at the end of a match besides the losers.
+type rotationT interface {
+	// name identifies the rotation in config and when creating rooms.
+	name() string
+	// rotate returns the seats of the winners who leave the court too, the losers' seats are vacated. It is
+	// called with the winners still seated.
+	rotate(c *courtT, vacated []int) []int
+}
+
+const defaultRotation = "king"
+
+// rotations are the available rotations by name.
+var rotations = map[string]func() rotationT{
+	"king":         func() rotationT { return kingRotation{} },
+	"winner-stays": func() rotationT { return &winnerStaysRotation{max: rotation.WinnerStays, wins: make(map[*player]int)} },
+	"both":         func() rotationT { return bothRotation{} },
+	"round-robin":  func() rotationT { return &roundRobinRotation{} },
+}
+
+// RotationSettings are how the public court rotates players, and how long winners stay on courts that limit it.
+type RotationSettings struct {
+	// Policy is the public court's rotation: king, winner-stays, both or round-robin.
+	Policy string
+	// WinnerStays is the most matches in a row a winner plays on winner-stays courts.
+	WinnerStays int
+}
+
+var rotation = RotationSettings{Policy: defaultRotation, WinnerStays: 3}
+
+// ConfigureRotation sets how players rotate from now on, the public court switches to the configured policy
+// straight away.
+func ConfigureRotation(settings RotationSettings) error {
+	rotation = settings
+
+	r, err := newRotation(settings.Policy)
+	if err != nil {
+		return err
+	}
+
+	court.lock.Lock()
+	defer court.lock.Unlock()
+
+	court.rotation = r
+
+	return nil
+}
+
+// newRotation returns the named rotation, the default rotation if name is empty.
+func newRotation(name string) (rotationT, error) {
+	if name == "" {
+		name = defaultRotation
+	}
+
+	newFn, ok := rotations[strings.ToLower(name)]
+	if !ok {
+		return nil, ErrUnknownRotation
+	}
+
+	return newFn(), nil
+}
+
+// winnerSeats returns the seats of the players still seated who aren't in vacated.
+func winnerSeats(c *courtT, vacated []int) []int {
+	lost := make(map[int]bool)
+	for _, i := range vacated {
+		lost[i] = true
+	}
+
+	var seats []int
+	for i, p := range c.seats {
+		if p != nil && !lost[i] {
+			seats = append(seats, i)
+		}
+	}
+	return seats
+}
+
+// kingRotation keeps the winners on the court for as long as they keep winning.
+type kingRotation struct{}
+
+func (kingRotation) name() string {
+	return "king"
+}
+
+func (kingRotation) rotate(c *courtT, vacated []int) []int {
+	return nil
+}
+
+// winnerStaysRotation keeps the winners on the court for at most max matches in a row.
+type winnerStaysRotation struct {
+	max  int
+	wins map[*player]int // matches in a row won by the players on the court
+}
+
+func (r *winnerStaysRotation) name() string {
+	return "winner-stays"
+}
+
+func (r *winnerStaysRotation) rotate(c *courtT, vacated []int) []int {
+	stays := winnerSeats(c, vacated)
+
+	wins := make(map[*player]int)
+	var leave []int
+	for _, i := range stays {
+		p := c.seats[i]
+		wins[p] = r.wins[p] + 1
+		if wins[p] >= r.max {
+			log.Printf("seat %d has won %d in a row and rotates off\n", i, wins[p])
+			leave = append(leave, i)
+			delete(wins, p)
+		}
+	}
+	// only the players still on the court are kept, a streak ends with a loss
+	r.wins = wins
+
+	return leave
+}
+
+// bothRotation sends everyone back to the wait list after every match.
+type bothRotation struct{}
+
+func (bothRotation) name() string {
+	return "both"
+}
+
+func (bothRotation) rotate(c *courtT, vacated []int) []int {
+	return winnerSeats(c, vacated)
+}
+
+// roundRobinRotation sends everyone back to the wait list after every match, and also picks who plays next so
+// that a fixed group of players each play everyone else in the group once, a round. The group is everyone waiting
+// when the round starts, anyone joining later waits for the next round. It takes the place of the court's
+// matchmaker.
+type roundRobinRotation struct {
+	group    []*player    // the players in the round
+	pairings [][2]*player // the round's pairings still to be played, in order
+}
+
+func (r *roundRobinRotation) name() string {
+	return "round-robin"
+}
+
+func (r *roundRobinRotation) rotate(c *courtT, vacated []int) []int {
+	return winnerSeats(c, vacated)
+}
+
+// start starts a new round for the group, scheduling their pairings by the circle method so that everyone's
+// matches are spread through the round. The group is in the order they joined, whoever has waited longest plays
+// in the first match.
+func (r *roundRobinRotation) start(group []*player) {
+	r.group = append([]*player(nil), group...)
+	r.pairings = nil
+
+	circle := append([]*player(nil), group...)
+	if len(circle)%2 == 1 {
+		// a nil player is a bye, given to the last to join first
+		circle = append([]*player{nil}, circle...)
+	}
+	n := len(circle)
+	for round := 0; round < n-1; round++ {
+		for i := 0; i < n/2; i++ {
+			if p, q := circle[i], circle[n-1-i]; p != nil && q != nil {
+				r.pairings = append(r.pairings, [2]*player{p, q})
+			}
+		}
+		// everyone but the first moves one place round the circle
+		circle = append(append([]*player{circle[0]}, circle[n-1]), circle[1:n-1]...)
+	}
+
+	log.Printf("round robin of %d players, %d matches\n", len(r.group), len(r.pairings))
+}
+
+// forget drops the pairings of players who have left.
+func (r *roundRobinRotation) forget() {
+	pairings := r.pairings[:0]
+	for _, pair := range r.pairings {
+		if pair[0].getState() != dead && pair[1].getState() != dead {
+			pairings = append(pairings, pair)
+		}
+	}
+	r.pairings = pairings
+}
+
+// pick picks the next pairing of the round whose players are both available, one with the seated player in it
+// if a seat is being refilled. A new round starts with everyone on the court once the last one's pairings have
+// been played. Seats left over, on courts for more than two, go to the rest of the group in the order they
+// joined.
+func (r *roundRobinRotation) pick(waiting []*player, seated []*player, n int, now time.Time) []*player {
+	r.forget()
+	if len(r.pairings) == 0 {
+		group := append(append([]*player(nil), seated...), waiting...)
+		if len(group) < 2 {
+			return nil
+		}
+		r.start(group)
+	}
+
+	available := func(p *player) bool {
+		return contains(waiting, p) || contains(seated, p)
+	}
+
+	var picked []*player
+	for i, pair := range r.pairings {
+		if !available(pair[0]) || !available(pair[1]) {
+			continue
+		}
+		// a seated player can only be refilled against, they can't be picked again
+		if len(seated) > 0 && !contains(seated, pair[0]) && !contains(seated, pair[1]) {
+			continue
+		}
+		for _, p := range pair {
+			if !contains(seated, p) {
+				picked = append(picked, p)
+			}
+		}
+		if len(picked) > n {
+			picked = nil
+			continue
+		}
+		r.pairings = append(r.pairings[:i], r.pairings[i+1:]...)
+		break
+	}
+	if len(picked) == 0 {
+		if len(seated) > 0 {
+			// nobody left in the round is due to play whoever was left on the court, rather than keep them
+			// there alone they play whoever is next
+			return fifoMatchmaker{}.pick(waiting, seated, n, now)
+		}
+		return nil
+	}
+
+	for _, p := range waiting {
+		if len(picked) >= n {
+			break
+		}
+		if contains(r.group, p) && !contains(picked, p) {
+			picked = append(picked, p)
+		}
+	}
+
+	return picked
+}
+
+// sendRotateMsg tells a winner they're leaving the court for the wait list.
+func (p *player) sendRotateMsg() {
+	p.queue("W")
+}
+
+func contains(players []*player, p *player) bool {
+	for _, q := range players {
+		if q == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func testGroup(n int) []*player {
+	var group []*player
+	for i := 0; i < n; i++ {
+		group = append(group, &player{id: fmt.Sprint("player-", i)})
+	}
+	return group
+}
+
+func TestRoundRobinStart(t *testing.T) {
+	for n := 2; n <= 7; n++ {
+		group := testGroup(n)
+		r := &roundRobinRotation{}
+		r.start(group)
+
+		if want := n * (n - 1) / 2; len(r.pairings) != want {
+			t.Errorf("%d players have %d pairings, want %d", n, len(r.pairings), want)
+		}
+		seen := make(map[[2]*player]bool)
+		for _, pair := range r.pairings {
+			if pair[0] == pair[1] || seen[pair] || seen[[2]*player{pair[1], pair[0]}] {
+				t.Errorf("%d players: pairing %s v %s twice or against themselves", n, pair[0].id, pair[1].id)
+			}
+			seen[pair] = true
+		}
+		if first := r.pairings[0]; first[0] != group[0] && first[1] != group[0] {
+			t.Errorf("%d players: first match %s v %s, want %s in it", n, first[0].id, first[1].id, group[0].id)
+		}
+	}
+}
+
+// playRoundRobin plays matches on a two seat court picked by r, everyone going back to the wait list after each,
+// and returns how many times each pair played in them. late joins the wait list after the first match.
+func playRoundRobin(r *roundRobinRotation, waiting []*player, matches int, late *player) map[[2]*player]int {
+	played := make(map[[2]*player]int)
+	for i := 0; i < matches; i++ {
+		picked := r.pick(waiting, nil, 2, time.Time{})
+		if len(picked) != 2 {
+			break
+		}
+		p, q := picked[0], picked[1]
+		if p.id > q.id {
+			p, q = q, p
+		}
+		played[[2]*player{p, q}]++
+
+		var still []*player
+		for _, w := range waiting {
+			if !contains(picked, w) {
+				still = append(still, w)
+			}
+		}
+		waiting = append(still, picked...)
+		if i == 0 && late != nil {
+			waiting = append(waiting, late)
+		}
+	}
+	return played
+}
+
+func TestRoundRobinPick(t *testing.T) {
+	tests := []struct {
+		name    string
+		players int
+		late    bool // another player joins during the round
+		leaves  int  // a player who leaves after the first match, -1 for nobody
+	}{
+		{name: "pair", players: 2, leaves: -1},
+		{name: "odd group", players: 5, leaves: -1},
+		{name: "even group", players: 6, leaves: -1},
+		{name: "late joiner waits for the next round", players: 4, late: true, leaves: -1},
+		{name: "leaver's matches are skipped", players: 5, leaves: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := testGroup(tt.players)
+			var late *player
+			if tt.late {
+				late = &player{id: "late"}
+			}
+			r := &roundRobinRotation{}
+
+			var played map[[2]*player]int
+			if tt.leaves >= 0 {
+				first := playRoundRobin(r, group, 1, nil)
+				group[tt.leaves].setState(dead)
+				group = append(group[:tt.leaves], group[tt.leaves+1:]...)
+				r.forget()
+				played = playRoundRobin(r, group, len(r.pairings), nil)
+				for pair, n := range first {
+					played[pair] += n
+				}
+			} else {
+				played = playRoundRobin(r, group, tt.players*(tt.players-1)/2, late)
+			}
+
+			for i, p := range group {
+				for _, q := range group[i+1:] {
+					if n := played[[2]*player{p, q}]; n != 1 {
+						t.Errorf("%s v %s played %d times in the round, want once", p.id, q.id, n)
+					}
+				}
+			}
+			for pair, n := range played {
+				if pair[0] == late || pair[1] == late {
+					t.Errorf("%s played %s v %s %d times before the next round", late.id, pair[0].id, pair[1].id, n)
+				}
+			}
+			if len(r.pairings) != 0 {
+				t.Errorf("%d pairings left after the round", len(r.pairings))
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"log"
+)
+
+// Segments are display only screens set up in a line between a court's LEFT and RIGHT sides, in the order they
+// joined. They have no paddle. A ball sent over the net goes into the segment next to the side it left, then
+// across each segment in turn until it leaves the last one into the other side. Segments hand the ball on with
+// the same net exchange (N) messages as players, the direction the ball is moving in says which way it goes.
+
+// addDisplay adds a display only segment to the far RIGHT end of the court's segments.
+func addDisplay(c *courtT, wsConn conn, id string, limits Limits, release func()) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.maxSegments() == 0 {
+		return ErrNoSegments
+	}
+	if len(c.segments) >= c.maxSegments() {
+		return ErrTooManySegments
+	}
+
+	d := newPlayer(c, wsConn, id, displaying, limits, release)
+	c.segments = append(c.segments, d)
+	log.Printf("display %s joined as segment %d\n", d.addr(), len(c.segments)-1)
+
+	c.numberSegments()
+
+	return nil
+}
+
+// maxSegments returns the most segments the court's mode allows, 0 if it doesn't have any.
+func (c *courtT) maxSegments() int {
+	if s, ok := c.mode.(segmentedT); ok {
+		return s.maxSegments()
+	}
+	return 0
+}
+
+// numberSegments tells every segment where it is in the line, after segments have joined or left.
+func (c *courtT) numberSegments() {
+	for i, d := range c.segments {
+		d.seat = i
+		d.sendDisplayMsg(i, len(c.segments))
+	}
+}
+
+// ensureSegments removes segments whose displays have gone. Any balls on them are lost, the court serves
+// another if none are left in play.
+func (c *courtT) ensureSegments() {
+	var alive []*player
+	for _, d := range c.segments {
+		if d.getState() == dead {
+			log.Printf("display segment %d left. addr: %s\n", d.seat, d.addr())
+			d.wsConn.Close()
+			continue
+		}
+		alive = append(alive, d)
+	}
+
+	if len(alive) != len(c.segments) {
+		c.segments = alive
+		c.numberSegments()
+	}
+}
+
+// doSegmentExchange passes balls on from segments that they have left, to the next segment in the direction
+// they are moving or into the side at that end.
+func (c *courtT) doSegmentExchange() error {
+	for i, d := range c.segments {
+		for id, msg := range d.netExchanges() {
+			yPos, angle, speed, err := parseNetExchange(msg)
+			if err != nil {
+				return err
+			}
+			d.dropBall(id)
+
+			next, side := i+1, right
+			if !movingRight(angle) {
+				next, side = i-1, left
+			}
+
+			if next >= 0 && next < len(c.segments) {
+				c.segments[next].sendBallInMsg(id, yPos, angle, speed)
+			} else {
+				c.sendBall(eventHandoff, c.side(side), id, yPos, angle, speed)
+			}
+		}
+	}
+
+	return nil
+}
+
+// dropSegmentBalls forgets about any balls on the segments, when there is nobody to pass them to. The
+// displays stop showing them once they reach the edge of the screen.
+func (c *courtT) dropSegmentBalls() {
+	for _, d := range c.segments {
+		d.dropBalls()
+	}
+}
+
+// side returns the seats on one side of the court.
+func (c *courtT) side(side sideT) []int {
+	var seats []int
+	for i, seat := range c.layout {
+		if seat.Side == side {
+			seats = append(seats, i)
+		}
+	}
+	return seats
+}
+
+// movingRight returns true if a ball at angle degrees is moving towards the RIGHT end of the court.
+func movingRight(angle int) bool {
+	angle = (angle%360 + 360) % 360
+	return angle < 90 || angle > 270
+}
+
+// sendDisplayMsg tells a display which segment it is of how many.
+func (p *player) sendDisplayMsg(segment int, segments int) {
+	p.queue(fmt.Sprintf("D,%d,%d", segment, segments))
+}
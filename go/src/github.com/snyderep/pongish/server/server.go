@@ -1,6 +1,8 @@
 package server
 
 import (
+	"crypto/tls"
+	"log"
 	"net/http"
 
 	"github.com/gorilla/mux"
@@ -11,6 +13,13 @@ type HandlerProvider interface {
 	homeHandler(w http.ResponseWriter, r *http.Request)
 	screenHandler(w http.ResponseWriter, r *http.Request)
 	gameHandler(w http.ResponseWriter, r *http.Request)
+	roomHandler(w http.ResponseWriter, r *http.Request)
+	replayHandler(w http.ResponseWriter, r *http.Request)
+	replayEventsHandler(w http.ResponseWriter, r *http.Request)
+	leaderboardHandler(w http.ResponseWriter, r *http.Request)
+	playerHandler(w http.ResponseWriter, r *http.Request)
+	playerRatingsHandler(w http.ResponseWriter, r *http.Request)
+	exportHandler(w http.ResponseWriter, r *http.Request)
 }
 
 // TemplateRenderer renders templates (of course).
@@ -18,12 +27,17 @@ type TemplateRenderer interface {
 	renderTemplate(w http.ResponseWriter, templateName string, data map[string]interface{}) error
 }
 
-// Server wraps an http server.
+// Server wraps an http server. If TLSCert and TLSKey are set the server speaks https (and HTTP/2) on Address,
+// reloading the certificate whenever the files change, and if RedirectAddress is also set plain http
+// requests to it are redirected to https.
 type Server struct {
-	Address      string
-	Provider     HandlerProvider
-	StaticPrefix string
-	StaticRoot   string
+	Address         string
+	Provider        HandlerProvider
+	StaticPrefix    string
+	StaticRoot      string
+	TLSCert         string
+	TLSKey          string
+	RedirectAddress string
 }
 
 // Listen starts listening for HTTP traffic.
@@ -39,7 +53,33 @@ func (s *Server) Listen() error {
 	// handle everything else
 	http.Handle("/", router)
 
-	return http.ListenAndServe(s.Address, nil)
+	if s.TLSCert == "" && s.TLSKey == "" {
+		return http.ListenAndServe(s.Address, nil)
+	}
+
+	certReloader, err := newCertReloader(s.TLSCert, s.TLSKey)
+	if err != nil {
+		return err
+	}
+
+	if s.RedirectAddress != "" {
+		go func() {
+			if err := http.ListenAndServe(s.RedirectAddress, httpsRedirectHandler(s.Address)); err != nil {
+				log.Printf("https redirect listener stopped: %s\n", err)
+			}
+		}()
+	}
+
+	srv := &http.Server{
+		Addr: s.Address,
+		TLSConfig: &tls.Config{
+			GetCertificate: certReloader.getCertificate,
+			MinVersion:     tls.VersionTLS12,
+		},
+	}
+
+	// HTTP/2 is enabled automatically by net/http when serving TLS.
+	return srv.ListenAndServeTLS("", "")
 }
 
 func (s *Server) setupRoutes() (*mux.Router, error) {
@@ -53,5 +93,20 @@ func (s *Server) setupRoutes() (*mux.Router, error) {
 	// game websocket handler
 	r.HandleFunc("/game", s.Provider.gameHandler)
 
+	// private room creation
+	r.HandleFunc("/room", s.Provider.roomHandler).Methods("POST")
+
+	// recorded match replays
+	r.HandleFunc("/replay/{matchID}", s.Provider.replayHandler)
+	r.HandleFunc("/replay/{matchID}/events", s.Provider.replayEventsHandler)
+
+	// player ratings
+	r.HandleFunc("/leaderboard", s.Provider.leaderboardHandler)
+	r.HandleFunc("/players/{playerID}", s.Provider.playerHandler)
+	r.HandleFunc("/players/{playerID}/ratings", s.Provider.playerRatingsHandler)
+
+	// match analytics
+	r.HandleFunc("/export/{kind}", s.Provider.exportHandler)
+
 	return r, nil
 }
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+const (
+	sessionName = "pongish-a"
+
+	// SessionKeysEnv is the environment variable that, when set, overrides the configured session keys.
+	// It holds whitespace separated keys in the same form as SessionSettings.Key.
+	SessionKeysEnv = "PONGISH_SESSION_KEYS"
+)
+
+// SessionSettings configures the session cookie.
+type SessionSettings struct {
+	// Key holds the session keys, newest first. Each key is a base64 authentication key, 32 or 64 bytes,
+	// optionally followed by a comma and a base64 encryption key, 16, 24 or 32 bytes. New sessions are
+	// written with the first key, older keys are still accepted when reading so keys can be rotated without
+	// logging everyone out.
+	Key []string
+	// MaxAge is the cookie lifetime in seconds.
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	// SameSite is one of "lax", "strict", "none" or empty for the browser default.
+	SameSite string
+}
+
+// sessionStore is a cookie store that also sets the SameSite cookie attribute, which gorilla's
+// sessions.Options doesn't know about.
+type sessionStore struct {
+	*sessions.CookieStore
+	sameSite http.SameSite
+}
+
+// NewSessionStore creates the session store described by settings. Keys in the SessionKeysEnv environment
+// variable take precedence over settings.Key. If there are no keys at all, random keys are generated, which
+// means sessions won't survive a restart.
+func NewSessionStore(settings SessionSettings) (sessions.Store, error) {
+	keys := settings.Key
+	if env := os.Getenv(SessionKeysEnv); env != "" {
+		keys = strings.Fields(env)
+	}
+
+	var keyPairs [][]byte
+	if len(keys) == 0 {
+		log.Println("no session keys configured, generating random keys; sessions will not survive a restart")
+		keyPairs = append(keyPairs, securecookie.GenerateRandomKey(64), securecookie.GenerateRandomKey(32))
+	}
+	for i, key := range keys {
+		authKey, encKey, err := parseSessionKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("session key %d: %s", i+1, err)
+		}
+		keyPairs = append(keyPairs, authKey, encKey)
+	}
+
+	sameSite, err := parseSameSite(settings.SameSite)
+	if err != nil {
+		return nil, err
+	}
+
+	cs := sessions.NewCookieStore(keyPairs...)
+	cs.Options.Secure = settings.Secure
+	cs.Options.HttpOnly = settings.HttpOnly
+	if settings.MaxAge != 0 {
+		cs.MaxAge(settings.MaxAge)
+	}
+
+	return &sessionStore{CookieStore: cs, sameSite: sameSite}, nil
+}
+
+// New is overridden so that sessions are saved through this store rather than the embedded one.
+func (s *sessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	var err error
+	if c, errCookie := r.Cookie(name); errCookie == nil {
+		err = securecookie.DecodeMulti(name, c.Value, &session.Values, s.Codecs...)
+		if err == nil {
+			session.IsNew = false
+		}
+	}
+
+	return session, err
+}
+
+// Get is overridden so that the registry caches sessions against this store rather than the embedded one.
+func (s *sessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// Save writes the session cookie including the SameSite attribute.
+func (s *sessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+
+	cookie := sessions.NewCookie(session.Name(), encoded, session.Options)
+	cookie.SameSite = s.sameSite
+	http.SetCookie(w, cookie)
+
+	return nil
+}
+
+func parseSessionKey(key string) ([]byte, []byte, error) {
+	parts := strings.SplitN(key, ",", 2)
+
+	authKey, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return nil, nil, fmt.Errorf("authentication key: %s", err)
+	}
+	if l := len(authKey); l != 32 && l != 64 {
+		return nil, nil, fmt.Errorf("authentication key must be 32 or 64 bytes, got %d", l)
+	}
+
+	if len(parts) == 1 {
+		return authKey, nil, nil
+	}
+
+	encKey, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return nil, nil, fmt.Errorf("encryption key: %s", err)
+	}
+	if l := len(encKey); l != 16 && l != 24 && l != 32 {
+		return nil, nil, fmt.Errorf("encryption key must be 16, 24 or 32 bytes, got %d", l)
+	}
+
+	return authKey, encKey, nil
+}
+
+func parseSameSite(sameSite string) (http.SameSite, error) {
+	switch strings.ToLower(sameSite) {
+	case "":
+		return http.SameSiteDefaultMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+
+	return http.SameSiteDefaultMode, fmt.Errorf("unknown SameSite mode %q", sameSite)
+}
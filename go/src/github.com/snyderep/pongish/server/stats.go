@@ -0,0 +1,241 @@
+package server
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// the most matches kept in each player's match history
+const maxMatchHistory = 50
+
+// seatStatsT is what the player in a seat did during a match.
+type seatStatsT struct {
+	Hits           int `json:"hits"`
+	Misses         int `json:"misses"`
+	ServesReceived int `json:"servesReceived"`
+}
+
+// playerStatsT are a player's totals over every match they've finished.
+type playerStatsT struct {
+	Played         int   `json:"played"`
+	Wins           int   `json:"wins"`
+	Hits           int   `json:"hits"`
+	Misses         int   `json:"misses"`
+	ServesReceived int   `json:"servesReceived"`
+	Rallies        int   `json:"rallies"`
+	RallyHits      int   `json:"rallyHits"` // the hits in all the rallies, for the average rally length
+	LongestRally   int   `json:"longestRally"`
+	PlayedMillis   int64 `json:"playedMillis"`
+}
+
+// matchSummaryT is a match in a player's match history.
+type matchSummaryT struct {
+	Match        string   `json:"match"`
+	T            int64    `json:"t"` // when the match started, unix seconds
+	Mode         string   `json:"mode"`
+	Won          bool     `json:"won"`
+	Opponents    []string `json:"opponents,omitempty"` // only the opponents with ids
+	Millis       int64    `json:"millis"`
+	Hits         int      `json:"hits"`
+	Misses       int      `json:"misses"`
+	LongestRally int      `json:"longestRally"`
+}
+
+// tally counts the event towards the match's stats. The match's lock must be held.
+func (m *matchT) tally(e matchEvent) {
+	seat := func() *seatStatsT {
+		if m.seatStats[e.Seat] == nil {
+			m.seatStats[e.Seat] = &seatStatsT{}
+		}
+		return m.seatStats[e.Seat]
+	}
+
+	switch e.Kind {
+	case eventServe:
+		seat().ServesReceived++
+		m.rallyHits[e.Ball] = 0
+	case eventHit:
+		seat().Hits++
+		m.rallyHits[e.Ball]++
+	case eventLost:
+		seat().Misses++
+		if hits, ok := m.rallyHits[e.Ball]; ok {
+			m.rallies = append(m.rallies, hits)
+			delete(m.rallyHits, e.Ball)
+		}
+	}
+}
+
+// matchResultT is how a finished match went for the players in it.
+type matchResultT struct {
+	match   string
+	start   time.Time
+	mode    string
+	millis  int64
+	rallies []int
+	players []resultPlayerT
+}
+
+type resultPlayerT struct {
+	player *player
+	id     string
+	won    bool
+	out    bool // the match is over for the player, so it counts towards their stats
+	stats  seatStatsT
+	rating float64 // before the match
+}
+
+// result returns how the match went for the players in seats, lost says which seats lost. Unless the match is
+// over, the winners play on and it's only over for the losers.
+func (m *matchT) result(mode string, now time.Time, seats []*player, lost map[int]bool, over bool) matchResultT {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	r := matchResultT{
+		match:   m.id,
+		start:   m.start,
+		mode:    mode,
+		millis:  int64(now.Sub(m.start) / time.Millisecond),
+		rallies: append([]int(nil), m.rallies...),
+	}
+	for i, p := range seats {
+		if p == nil || p.id == "" {
+			continue
+		}
+		rp := resultPlayerT{player: p, id: p.id, won: !lost[i], out: over || lost[i], rating: playerRating(p)}
+		if s := m.seatStats[i]; s != nil {
+			rp.stats = *s
+		}
+		r.players = append(r.players, rp)
+	}
+
+	return r
+}
+
+// recordStats adds a match to the stats and match history of the players it is over for.
+func recordStats(r matchResultT) {
+	if playerStore == nil || len(r.players) == 0 {
+		return
+	}
+
+	byID := make(map[string]resultPlayerT)
+	var ids []string
+	for _, p := range r.players {
+		if _, ok := byID[p.id]; ok {
+			log.Printf("not keeping stats of match %s, player %s is in it twice\n", r.match, p.id)
+			return
+		}
+		byID[p.id] = p
+		if p.out {
+			ids = append(ids, p.id)
+		}
+	}
+
+	longest, rallyHits := longestRally(r.rallies), 0
+	for _, hits := range r.rallies {
+		rallyHits += hits
+	}
+
+	earned := make(map[string][]badgeT)
+	err := playerStore.update(ids, func(rec *playerRecordT) {
+		p := byID[rec.ID]
+
+		s := &rec.Stats
+		s.Played++
+		if p.won {
+			s.Wins++
+		}
+		s.Hits += p.stats.Hits
+		s.Misses += p.stats.Misses
+		s.ServesReceived += p.stats.ServesReceived
+		s.Rallies += len(r.rallies)
+		s.RallyHits += rallyHits
+		if longest > s.LongestRally {
+			s.LongestRally = longest
+		}
+		s.PlayedMillis += r.millis
+
+		var opponents []string
+		for _, o := range r.players {
+			if o.won != p.won {
+				opponents = append(opponents, o.id)
+				if rec.Opponents == nil {
+					rec.Opponents = make(map[string]int)
+				}
+				rec.Opponents[o.id]++
+			}
+		}
+
+		rec.Recent = append(rec.Recent, matchSummaryT{Match: r.match, T: r.start.Unix(), Mode: r.mode, Won: p.won,
+			Opponents: opponents, Millis: r.millis, Hits: p.stats.Hits, Misses: p.stats.Misses, LongestRally: longest})
+		if len(rec.Recent) > maxMatchHistory {
+			rec.Recent = rec.Recent[len(rec.Recent)-maxMatchHistory:]
+		}
+
+		earned[rec.ID] = award(rec, p, r)
+	})
+	if err != nil {
+		log.Printf("error saving stats for match %s: %s\n", r.match, err)
+	}
+
+	for _, p := range r.players {
+		if !p.out {
+			continue
+		}
+		for _, b := range earned[p.id] {
+			log.Printf("player %s earned %s in match %s\n", p.id, b.ID, r.match)
+			p.player.announce(b)
+		}
+	}
+}
+
+// add adds the stats of more matches to the totals.
+func (s *playerStatsT) add(more playerStatsT) {
+	s.Played += more.Played
+	s.Wins += more.Wins
+	s.Hits += more.Hits
+	s.Misses += more.Misses
+	s.ServesReceived += more.ServesReceived
+	s.Rallies += more.Rallies
+	s.RallyHits += more.RallyHits
+	if more.LongestRally > s.LongestRally {
+		s.LongestRally = more.LongestRally
+	}
+	s.PlayedMillis += more.PlayedMillis
+}
+
+// winRate returns the percentage of their matches the player won.
+func (s playerStatsT) winRate() int {
+	if s.Played == 0 {
+		return 0
+	}
+	return s.Wins * 100 / s.Played
+}
+
+// averageRally returns the average number of hits in the rallies of the player's matches.
+func (s playerStatsT) averageRally() float64 {
+	if s.Rallies == 0 {
+		return 0
+	}
+	return float64(s.RallyHits) / float64(s.Rallies)
+}
+
+// favouriteOpponents returns the ids of the n players the player has played most, most played first.
+func (r *playerRecordT) favouriteOpponents(n int) []string {
+	var ids []string
+	for id := range r.Opponents {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if r.Opponents[ids[i]] != r.Opponents[ids[j]] {
+			return r.Opponents[ids[i]] > r.Opponents[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+
+	if len(ids) > n {
+		ids = ids[:n]
+	}
+	return ids
+}
@@ -25,10 +25,11 @@ func NewNormalTemplateRenderer(templateRoot string) *normalTemplateRenderer {
 		log.Fatal(err)
 	}
 
-	// Generate our templates map from our layouts/ and includes/ directories
-	for _, layout := range layouts {
-		files := append(includes, layout)
-		templates[filepath.Base(files[0])] = template.Must(template.ParseFiles(files...))
+	// Generate our templates map from our layouts/ and includes/ directories, each include is a page
+	// rendered within the layouts.
+	for _, include := range includes {
+		files := append(append([]string{}, layouts...), include)
+		templates[filepath.Base(include)] = template.Must(template.ParseFiles(files...))
 	}
 
 	return &normalTemplateRenderer{templates: templates}
@@ -0,0 +1,22 @@
+package server
+
+import "strings"
+
+// themeNames are the colour themes the web client has, see theme.js.go in pongishweb. A court can be given one
+// of them, players can still choose their own.
+var themeNames = []string{"classic", "night", "high-contrast", "colour-blind"}
+
+// findTheme returns the name of a theme as the client knows it, empty for an empty name.
+func findTheme(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+
+	for _, t := range themeNames {
+		if strings.EqualFold(t, name) {
+			return t, nil
+		}
+	}
+
+	return "", ErrUnknownTheme
+}
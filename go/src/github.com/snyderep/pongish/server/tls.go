@@ -0,0 +1,124 @@
+package server
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+// certReloadDelay lets a renewal that writes the cert and key separately finish before reloading.
+const certReloadDelay = time.Duration(500) * time.Millisecond
+
+// certReloaderT holds the current TLS certificate and reloads it when the cert or key files change.
+type certReloaderT struct {
+	certFile string
+	keyFile  string
+	lock     sync.RWMutex
+	cert     *tls.Certificate
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloaderT, error) {
+	cr := &certReloaderT{certFile: certFile, keyFile: keyFile}
+	if err := cr.load(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	// Watch the directories rather than the files, renewals commonly replace the files rather than
+	// writing to them, which would silently end a watch on the file itself.
+	dirs := map[string]bool{filepath.Dir(certFile): true, filepath.Dir(keyFile): true}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+
+	go cr.watch(watcher)
+
+	return cr, nil
+}
+
+func (cr *certReloaderT) load() error {
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cr.lock.Lock()
+	cr.cert = &cert
+	cr.lock.Unlock()
+
+	return nil
+}
+
+func (cr *certReloaderT) watch(watcher *fsnotify.Watcher) {
+	defer watcher.Close()
+
+	var reload <-chan time.Time
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			name := filepath.Clean(event.Name)
+			if name == filepath.Clean(cr.certFile) || name == filepath.Clean(cr.keyFile) {
+				reload = time.After(certReloadDelay)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("certificate watcher error: %s\n", err)
+		case <-reload:
+			reload = nil
+			if err := cr.load(); err != nil {
+				log.Printf("error reloading certificate, keeping the previous one: %s\n", err)
+			} else {
+				log.Printf("reloaded certificate %s\n", cr.certFile)
+			}
+		}
+	}
+}
+
+// getCertificate is used as tls.Config.GetCertificate.
+func (cr *certReloaderT) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.lock.RLock()
+	defer cr.lock.RUnlock()
+
+	return cr.cert, nil
+}
+
+// httpsRedirectHandler redirects everything to the same URL on https at the port of tlsAddress.
+func httpsRedirectHandler(tlsAddress string) http.Handler {
+	_, tlsPort, _ := net.SplitHostPort(tlsAddress)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.Trim(r.Host, "[]")
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if tlsPort != "" && tlsPort != "443" {
+			host = net.JoinHostPort(host, tlsPort)
+		}
+
+		target := *r.URL
+		target.Scheme = "https"
+		target.Host = host
+
+		http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+	})
+}
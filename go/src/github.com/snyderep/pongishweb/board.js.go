@@ -0,0 +1,165 @@
+// +build js
+
+package main
+
+import (
+	"math"
+
+	"honnef.co/go/js/dom"
+)
+
+const goalPostWidth int = 6
+
+// board is the layout of the obstacles on a half of the court, see boardT in the server. It is defined as seen on
+// the LEFT half, the RIGHT half is a mirror image.
+type board struct {
+	Name      string     `json:"name"`
+	Obstacles []obstacle `json:"obstacles"`
+	Goal      *goal      `json:"goal"`
+}
+
+type obstacle struct {
+	Kind  string `json:"kind"`
+	X     int    `json:"x"`
+	Y     int    `json:"y"`
+	W     int    `json:"w"`
+	H     int    `json:"h"`
+	R     int    `json:"r"`
+	Speed int    `json:"speed"`
+	MinY  int    `json:"minY"`
+	MaxY  int    `json:"maxY"`
+}
+
+type goal struct {
+	Top    int `json:"top"`
+	Bottom int `json:"bottom"`
+}
+
+// rect returns where a block or barrier is on a side's half of the court width wide, frames into the match.
+func (o *obstacle) rect(side string, width int, frames int) (int, int, int, int) {
+	x, y := o.X, o.Y
+	if side == "RIGHT" {
+		x = width - o.X - o.W
+	}
+	if o.Kind == "barrier" {
+		// barriers go back and forth between MinY and MaxY
+		travel := o.MaxY - o.MinY
+		pos := (frames * o.Speed) % (2 * travel)
+		if pos > travel {
+			pos = 2*travel - pos
+		}
+		y = o.MinY + pos
+	}
+	return x, y, o.W, o.H
+}
+
+// centre returns where the centre of a bumper is on a side's half of the court width wide.
+func (o *obstacle) centre(side string, width int) (int, int) {
+	if side == "RIGHT" {
+		return width - o.X, o.Y
+	}
+	return o.X, o.Y
+}
+
+// collide bounces a ball off any obstacles it has run into. A ball an obstacle sends back the way it came can be
+// returned by the paddle again, even if the paddle has already hit it.
+func (bd *board) collide(b *ball, side string, width int, frames int) {
+	if bd == nil {
+		return
+	}
+
+	before := b.xMovement
+	defer func() {
+		if (before < 0) != (b.xMovement < 0) {
+			b.hit = false
+		}
+	}()
+
+	for i := range bd.Obstacles {
+		o := &bd.Obstacles[i]
+
+		if o.Kind == "bumper" {
+			cx, cy := o.centre(side, width)
+			dx, dy := float64(b.xPos-cx), float64(b.yPos-cy)
+			dist := math.Sqrt(dx*dx + dy*dy)
+			if dist == 0 || dist >= float64(b.radius+o.R) {
+				continue
+			}
+			// reflect the ball about the line from the centre of the bumper, if it's moving towards it
+			nx, ny := dx/dist, dy/dist
+			if dot := b.xMovement*nx + b.yMovement*ny; dot < 0 {
+				b.xMovement -= 2 * dot * nx
+				b.yMovement -= 2 * dot * ny
+			}
+			continue
+		}
+
+		x, y, w, h := o.rect(side, width, frames)
+		bounceOffRect(b, x, y, w, h)
+	}
+}
+
+// bounceOffRect sends a ball that has run into a rectangle away from the side of it that it hit. It returns
+// true if the ball touched the rectangle.
+func bounceOffRect(b *ball, x int, y int, w int, h int) bool {
+	// the point in the rectangle nearest the centre of the ball
+	nearX := math.Max(float64(x), math.Min(float64(b.xPos), float64(x+w)))
+	nearY := math.Max(float64(y), math.Min(float64(b.yPos), float64(y+h)))
+	dx, dy := float64(b.xPos)-nearX, float64(b.yPos)-nearY
+	if dx*dx+dy*dy >= float64(b.radius*b.radius) {
+		return false
+	}
+
+	if math.Abs(dx) >= math.Abs(dy) {
+		if dx < 0 || (dx == 0 && b.xMovement > 0) {
+			b.xMovement = -math.Abs(b.xMovement)
+		} else {
+			b.xMovement = math.Abs(b.xMovement)
+		}
+	} else if dy < 0 {
+		b.yMovement = -math.Abs(b.yMovement)
+	} else {
+		b.yMovement = math.Abs(b.yMovement)
+	}
+
+	return true
+}
+
+// blocksGoal returns true if the end wall, rather than the goal, is where a ball reached the end of the half.
+func (bd *board) blocksGoal(b *ball) bool {
+	return bd != nil && bd.Goal != nil && (b.yPos < bd.Goal.Top || b.yPos > bd.Goal.Bottom)
+}
+
+// render draws the board on a side's half of the court, width by height and offset across the canvas by offset.
+func (bd *board) render(ctx *dom.CanvasRenderingContext2D, th *theme, side string, width int, height int, offset int, frames int) {
+	if bd == nil {
+		return
+	}
+
+	for i := range bd.Obstacles {
+		o := &bd.Obstacles[i]
+		ctx.FillStyle = th.obstacles[o.Kind]
+
+		if o.Kind == "bumper" {
+			cx, cy := o.centre(side, width)
+			ctx.BeginPath()
+			ctx.Arc(offset+cx, cy, o.R, 0, 7, false)
+			ctx.Fill()
+			ctx.ClosePath()
+			continue
+		}
+
+		x, y, w, h := o.rect(side, width, frames)
+		ctx.FillRect(offset+x, y, w, h)
+	}
+
+	if bd.Goal != nil {
+		ctx.FillStyle = th.obstacles["goal"]
+		x := offset
+		if side == "RIGHT" {
+			x = offset + width - goalPostWidth
+		}
+		ctx.FillRect(x, 0, goalPostWidth, bd.Goal.Top)
+		ctx.FillRect(x, bd.Goal.Bottom, goalPostWidth, height-bd.Goal.Bottom)
+	}
+}
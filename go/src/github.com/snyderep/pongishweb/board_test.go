@@ -0,0 +1,40 @@
+// +build js
+
+package main
+
+import "testing"
+
+func TestBoardCollide(t *testing.T) {
+	bd := &board{Obstacles: []obstacle{
+		{Kind: "block", X: 600, Y: 400, W: 100, H: 200},
+		{Kind: "bumper", X: 300, Y: 500, R: 30},
+	}}
+
+	tests := []struct {
+		name  string
+		ball  ball
+		wantX float64
+		wantY float64
+		want  bool // hit afterwards
+	}{
+		{name: "block sends a returned ball back", ball: ball{xPos: 590, yPos: 500, radius: 15, xMovement: 5, hit: true},
+			wantX: -5, want: false},
+		{name: "bumper sends a returned ball back", ball: ball{xPos: 260, yPos: 500, radius: 15, xMovement: 5, hit: true},
+			wantX: -5, want: false},
+		{name: "glancing off the top of a block", ball: ball{xPos: 650, yPos: 390, radius: 15, xMovement: 5, yMovement: 5,
+			hit: true}, wantX: 5, wantY: -5, want: true},
+		{name: "block sends a ball back towards the net",
+			ball: ball{xPos: 710, yPos: 500, radius: 15, xMovement: -5}, wantX: 5, want: false},
+		{name: "clear of the obstacles", ball: ball{xPos: 1000, yPos: 500, radius: 15, xMovement: 5, hit: true},
+			wantX: 5, want: true},
+	}
+
+	for _, tt := range tests {
+		b := tt.ball
+		bd.collide(&b, "LEFT", courtWidth, 0)
+		if b.xMovement != tt.wantX || b.yMovement != tt.wantY || b.hit != tt.want {
+			t.Errorf("%s: movement %v,%v hit %t, want %v,%v hit %t", tt.name, b.xMovement, b.yMovement, b.hit,
+				tt.wantX, tt.wantY, tt.want)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"strings"
 	"time"
 
 	//"honnef.co/go/js/console"
@@ -17,6 +18,17 @@ const (
 	ballEndAngle             float64 = math.Pi * 2.0
 	degreeToRadian           float64 = math.Pi / 180.0
 	radianToDegree           float64 = 180 / math.Pi
+	ballRadius               int     = 20
+	paddleOffsetFromEnd      int     = 10
+	paddleWidth              int     = 20
+	paddleHeight             int     = 150
+	paddleStartYPos          int     = 350
+	paddleSpeed              int     = 4
+	powerUpRadius            int     = 25
+
+	// size of one player's half of the court in logical units, the units the server talks in
+	courtWidth  int = 1300
+	courtHeight int = 1000
 )
 
 type ball struct {
@@ -25,14 +37,51 @@ type ball struct {
 	xPos      int
 	yPos      int
 	radius    int
+	hit       bool // the ball has already bounced off a paddle on this screen
 }
 
-func (b *ball) draw(canvasEl *dom.HTMLCanvasElement) {
+func (b *ball) draw(ctx *dom.CanvasRenderingContext2D, th *theme) {
+	b.move()
+	b.render(ctx, th)
+}
+
+// move advances the ball by one animation frame.
+func (b *ball) move() {
 	b.xPos += int(math.Floor(b.xMovement + 0.5))
 	b.yPos += int(math.Floor(b.yMovement + 0.5))
+}
+
+// bounce reverses the vertical direction of the ball if it has reached the top or bottom of a court height high.
+// It returns true if the ball bounced.
+func (b *ball) bounce(height int) bool {
+	if b.yPos <= b.radius || b.yPos >= height-b.radius {
+		b.yMovement *= -1
+		return true
+	}
+	return false
+}
+
+// vector returns the direction of the ball in degrees and its speed, as sent in messages.
+func (b *ball) vector() (int, int) {
+	rad := math.Atan2(b.yMovement, b.xMovement)
+	deg := rad * radianToDegree
+	if deg < 0 {
+		deg = 360 + deg
+	}
+	speed := math.Floor(b.xMovement/math.Cos(rad) + 0.5)
+	if speed < 2 {
+		speed = 2
+	}
+	return int(deg), int(speed)
+}
+
+func (b *ball) render(ctx *dom.CanvasRenderingContext2D, th *theme) {
+	ctx.FillStyle = th.ball
+	if th.ballShape == "square" {
+		ctx.FillRect(b.xPos-b.radius, b.yPos-b.radius, 2*b.radius, 2*b.radius)
+		return
+	}
 
-	ctx := canvasEl.GetContext2d()
-	ctx.FillStyle = "red"
 	ctx.BeginPath()
 	ctx.Arc(b.xPos, b.yPos, b.radius, 0, 6, false)
 	ctx.Fill()
@@ -45,30 +94,148 @@ type paddle struct {
 	yPos      int
 	height    int
 	width     int
-	hit       bool
+	top       int // the paddle stays between top and bottom, its lane of the court
+	bottom    int
+}
+
+// newPaddle creates a paddle for a side of a court courtHeight high, in lane of lanes.
+func newPaddle(side string, lane int, lanes int, courtWidth int, courtHeight int) *paddle {
+	if lanes < 1 {
+		lanes = 1
+	}
+	laneHeight := courtHeight / lanes
+	top := lane * laneHeight
+
+	yPos := paddleStartYPos
+	if lanes > 1 {
+		yPos = top + (laneHeight-paddleHeight)/2
+	}
+
+	return &paddle{
+		xPos:   paddleXPos(side, courtWidth),
+		yPos:   yPos,
+		height: paddleHeight,
+		width:  paddleWidth,
+		top:    top,
+		bottom: top + laneHeight,
+	}
 }
 
-func (p *paddle) draw(canvasEl *dom.HTMLCanvasElement) {
+func (p *paddle) draw(ctx *dom.CanvasRenderingContext2D, th *theme, color string) {
+	p.move()
+	p.render(ctx, th, color)
+}
+
+// move advances the paddle by one animation frame, keeping it in its lane.
+func (p *paddle) move() {
 	newYPos := p.yPos + p.yMovement
-	if newYPos > 5 && newYPos < (canvasEl.Height-p.height-5) {
+	if newYPos > p.top+5 && newYPos < (p.bottom-p.height-5) {
 		p.yPos = newYPos
 	}
+}
+
+// setHeight changes the height of the paddle about its middle, keeping it in its lane.
+func (p *paddle) setHeight(height int) {
+	if height == p.height {
+		return
+	}
+
+	middle := p.yPos + p.height/2
+	p.height = height
+	p.yPos = middle - height/2
+	if p.yPos <= p.top+5 {
+		p.yPos = p.top + 6
+	}
+	if p.yPos >= p.bottom-p.height-5 {
+		p.yPos = p.bottom - p.height - 6
+	}
+}
+
+// render draws the paddle in color, in the shape of the theme.
+func (p *paddle) render(ctx *dom.CanvasRenderingContext2D, th *theme, color string) {
+	ctx.FillStyle = color
+	if th.paddleShape != "pill" {
+		ctx.FillRect(p.xPos, p.yPos, p.width, p.height)
+		return
+	}
+
+	// a rectangle with rounded ends
+	r := p.width / 2
+	ctx.FillRect(p.xPos, p.yPos+r, p.width, p.height-2*r)
+	ctx.BeginPath()
+	ctx.Arc(p.xPos+r, p.yPos+r, r, 0, 7, false)
+	ctx.Arc(p.xPos+r, p.yPos+p.height-r, r, 0, 7, false)
+	ctx.Fill()
+	ctx.ClosePath()
+}
+
+// touches returns true if a ball is touching the paddle.
+func (p *paddle) touches(b *ball) bool {
+	nearX := math.Max(float64(p.xPos), math.Min(float64(b.xPos), float64(p.xPos+p.width)))
+	nearY := math.Max(float64(p.yPos), math.Min(float64(b.yPos), float64(p.yPos+p.height)))
+	dx, dy := float64(b.xPos)-nearX, float64(b.yPos)-nearY
+	return dx*dx+dy*dy < float64(b.radius*b.radius)
+}
+
+// powerUp is an item on the court that a ball collects by hitting it. The server decides what it does.
+type powerUp struct {
+	kind string
+	xPos int
+	yPos int
+}
+
+func (u *powerUp) render(ctx *dom.CanvasRenderingContext2D, th *theme) {
+	ctx.FillStyle = th.powerUpColor(u.kind)
+	ctx.BeginPath()
+	ctx.Arc(u.xPos, u.yPos, powerUpRadius, 0, 7, false)
+	ctx.Fill()
+	ctx.ClosePath()
 
-	ctx := canvasEl.GetContext2d()
-	ctx.FillStyle = "#0000ff"
-	ctx.FillRect(p.xPos, p.yPos, p.width, p.height)
+	ctx.FillStyle = th.powerUpText
+	ctx.Font = "bold 24px sans-serif"
+	ctx.TextAlign = "center"
+	ctx.TextBaseline = "middle"
+	ctx.FillText(strings.ToUpper(u.kind[:1]), u.xPos, u.yPos, -1)
+}
+
+// collects returns true if a ball is touching the power-up.
+func (u *powerUp) collects(b *ball) bool {
+	dx := float64(b.xPos - u.xPos)
+	dy := float64(b.yPos - u.yPos)
+	return math.Sqrt(dx*dx+dy*dy) < float64(b.radius+powerUpRadius)
 }
 
 type canvas struct {
 	canvasEl *dom.HTMLCanvasElement
-	bll      *ball
+	view     *viewport     // the court is drawn in logical units whatever the size of the canvas
+	balls    map[int]*ball // by id
 	pddl     *paddle
+	mates    map[int]*paddle // teammates' paddles by lane
 	side     string
+	display  bool                 // a display only segment of the court, with no paddle
+	powerUps map[int]*powerUp     // by id
+	effects  map[string]time.Time // power-up effects on this player and when they wear off
+	board    *board               // nil for an empty court
+	started  time.Time            // when the board was set up, moving obstacles are positioned from then
+	local    *localGame           // a game in the browser while not playing online, nil if there isn't one
+	sounds   *sounds
+	theme    *theme
+	paused   bool      // the match is paused, see frozen
+	resumeAt time.Time // when play resumes after a pause, zero until the countdown starts
 	event    chan string
 }
 
 func newCanvas(canvasEl *dom.HTMLCanvasElement) *canvas {
-	c := &canvas{canvasEl: canvasEl, event: make(chan string)}
+	c := &canvas{
+		canvasEl: canvasEl,
+		view:     newViewport(canvasEl, courtWidth, courtHeight),
+		balls:    make(map[int]*ball),
+		powerUps: make(map[int]*powerUp),
+		effects:  make(map[string]time.Time),
+		sounds:   newSounds(),
+		theme:    findTheme(defaultTheme),
+		event:    make(chan string),
+	}
 
 	canvasEl.AddEventListener("keydown", false, func(event dom.Event) {
 		c.handleKeyDown(event.(*dom.KeyboardEvent))
@@ -83,26 +250,39 @@ func newCanvas(canvasEl *dom.HTMLCanvasElement) *canvas {
 		for {
 			<-ticker.C
 
+			if c.local != nil {
+				c.local.step(c.view.context())
+				continue
+			}
+
 			c.draw()
+			if c.frozen() {
+				c.drawPause()
+				continue
+			}
 
-			if c.checkLost() {
-				c.bll = nil
-				c.event <- "L"
-			} else {
-				c.checkTopBottomCollision()
-				c.checkPaddleCollision()
-				if c.checkOverNet() {
-					rad := math.Atan2(c.bll.yMovement, c.bll.xMovement)
-					deg := rad * radianToDegree
-					if deg < 0 {
-						deg = 360 + deg
-					}
-					speed := math.Floor(c.bll.xMovement/math.Cos(rad) + 0.5)
-					if speed < 2 {
-						speed = 2
+			for id, b := range c.balls {
+				if c.checkLost(b) {
+					if c.active("shield") || c.board.blocksGoal(b) {
+						// the shield, or the end wall either side of a narrowed goal, returns the ball
+						// like a paddle would
+						c.returnBall(id, b)
+						continue
 					}
-					c.event <- fmt.Sprintf("N,%d,%d,%d", c.bll.yPos, int(deg), int(speed))
-					c.bll = nil
+					// the player is off the court, the rest of the balls go with them
+					c.balls = make(map[int]*ball)
+					c.event <- fmt.Sprintf("L,%d", id)
+					break
+				}
+
+				c.checkTopBottomCollision(b)
+				c.board.collide(b, c.side, c.view.width, c.frames())
+				c.checkPaddleCollision(id, b)
+				c.checkPowerUpCollision(id, b)
+				if c.checkOverNet(b) {
+					deg, speed := b.vector()
+					c.event <- fmt.Sprintf("N,%d,%d,%d,%d", b.yPos, deg, speed, id)
+					delete(c.balls, id)
 				}
 			}
 		}
@@ -112,63 +292,167 @@ func newCanvas(canvasEl *dom.HTMLCanvasElement) *canvas {
 }
 
 func (c *canvas) handleKeyDown(e *dom.KeyboardEvent) {
-	if e.KeyIdentifier == "Up" {
-		c.pddl.yMovement = -4
-	} else if e.KeyIdentifier == "Down" {
-		c.pddl.yMovement = 4
+	key := keyName(e)
+	if c.local != nil {
+		c.local.key(key, true)
+		return
+	}
+
+	if key == "Up" {
+		c.setPaddleMovement(-paddleSpeed)
+	} else if key == "Down" {
+		c.setPaddleMovement(paddleSpeed)
+	} else if key == "P" && c.pddl != nil {
+		c.requestPause()
 	}
 }
 
-func (c *canvas) handleKeyUp(e *dom.KeyboardEvent) {
-	if e.KeyIdentifier == "Up" || e.KeyIdentifier == "Down" {
-		c.pddl.yMovement = 0
+// requestPause asks the server to pause the match, or to resume it if it's paused.
+func (c *canvas) requestPause() {
+	msg := "Z"
+	if c.paused {
+		msg = "R"
 	}
+
+	// key handlers are javascript callbacks which mustn't block
+	go func() {
+		c.event <- msg
+	}()
 }
 
-func (c *canvas) ballStart(v *vector) {
-	var xPos int
+func (c *canvas) handleKeyUp(e *dom.KeyboardEvent) {
+	key := keyName(e)
+	if c.local != nil {
+		c.local.key(key, false)
+		return
+	}
 
-	if c.side == "LEFT" {
-		xPos = c.canvasEl.Width - 5
-	} else {
-		xPos = 5
+	if key == "Up" || key == "Down" {
+		c.setPaddleMovement(0)
 	}
+}
 
+// setPaddleMovement changes the paddle's movement and tells the server where the paddle is so that
+// the match can be recorded.
+func (c *canvas) setPaddleMovement(yMovement int) {
+	if c.pddl == nil || c.pddl.yMovement == yMovement {
+		return
+	}
+
+	c.pddl.yMovement = yMovement
+
+	// key handlers are javascript callbacks which mustn't block
+	msg := fmt.Sprintf("M,%d,%d", c.pddl.yPos, yMovement)
+	go func() {
+		c.event <- msg
+	}()
+}
+
+func (c *canvas) ballStart(id int, v *vector) {
 	radians := v.angle * degreeToRadian
 
 	xMovement := math.Cos(radians) * v.speed
 	yMovement := math.Sin(radians) * v.speed
 
-	c.bll = &ball{xPos: xPos, yPos: v.yPos, radius: 20, xMovement: xMovement, yMovement: yMovement}
-	c.pddl.hit = false
+	xPos := entryXPos(xMovement, c.view.width)
+
+	c.balls[id] = &ball{xPos: xPos, yPos: v.yPos, radius: ballRadius, xMovement: xMovement, yMovement: yMovement}
 }
 
 func (c *canvas) draw() {
 	c.clear()
 
-	if c.bll != nil {
-		c.bll.draw(c.canvasEl)
+	ctx := c.view.context()
+	c.board.render(ctx, c.theme, c.side, c.view.width, c.view.height, 0, c.frames())
+
+	// everything stays where it is while the match is paused
+	frozen := c.frozen()
+	for _, b := range c.balls {
+		if frozen {
+			b.render(ctx, c.theme)
+		} else {
+			b.draw(ctx, c.theme)
+		}
+	}
+	if c.pddl != nil {
+		if frozen {
+			c.pddl.render(ctx, c.theme, c.theme.paddle)
+		} else {
+			c.pddl.draw(ctx, c.theme, c.theme.paddle)
+		}
+	}
+	for _, mate := range c.mates {
+		if frozen {
+			mate.render(ctx, c.theme, c.theme.mate)
+		} else {
+			mate.draw(ctx, c.theme, c.theme.mate)
+		}
+	}
+	for _, u := range c.powerUps {
+		u.render(ctx, c.theme)
 	}
 	if c.pddl != nil {
-		c.pddl.draw(c.canvasEl)
+		c.pddl.setHeight(c.paddleHeight())
+		if c.active("shield") {
+			ctx.FillStyle = c.theme.powerUpColor("shield")
+			if c.side == "LEFT" {
+				ctx.FillRect(0, 0, 4, c.view.height)
+			} else {
+				ctx.FillRect(c.view.width-4, 0, 4, c.view.height)
+			}
+		}
+	}
+}
+
+// drawPause shows that the match is paused, or how long until it resumes.
+func (c *canvas) drawPause() {
+	text := "Paused"
+	if !c.resumeAt.IsZero() {
+		text = fmt.Sprintf("%d", int(c.resumeAt.Sub(time.Now())/time.Second)+1)
 	}
+
+	ctx := c.view.context()
+	ctx.FillStyle = c.theme.text
+	ctx.Font = "bold 96px sans-serif"
+	ctx.TextAlign = "center"
+	ctx.TextBaseline = "middle"
+	ctx.FillText(text, c.view.width/2, c.view.height/2, -1)
+}
+
+// pause holds the balls and paddles where they are until the match resumes.
+func (c *canvas) pause() {
+	c.paused = true
+	c.resumeAt = time.Time{}
+}
+
+// resumeIn resumes the match after a countdown.
+func (c *canvas) resumeIn(d time.Duration) {
+	c.resumeAt = time.Now().Add(d)
+}
+
+// frozen returns true while the match is paused.
+func (c *canvas) frozen() bool {
+	if c.paused && !c.resumeAt.IsZero() && !time.Now().Before(c.resumeAt) {
+		c.paused = false
+		c.resumeAt = time.Time{}
+	}
+	return c.paused
 }
 
 func (c *canvas) clear() {
-	ctx := c.canvasEl.GetContext2d()
-	ctx.ClearRect(0, 0, c.canvasEl.Width, c.canvasEl.Height)
+	c.theme.clear(c.view.context(), c.view.width, c.view.height)
 }
 
-func (c *canvas) checkLost() bool {
+func (c *canvas) checkLost(b *ball) bool {
 	// if the ball hits the end wall then the player has lost,
 	lost := false
 
-	if c.bll != nil {
+	if !c.display {
 		if c.side == "LEFT" {
-			if c.bll.xPos <= 0 {
+			if b.xPos <= 0 && b.xMovement < 0 {
 				lost = true
 			}
-		} else if c.bll.xPos >= c.canvasEl.Width {
+		} else if b.xPos >= c.view.width && b.xMovement > 0 {
 			lost = true
 		}
 	}
@@ -176,62 +460,204 @@ func (c *canvas) checkLost() bool {
 	return lost
 }
 
-func (c *canvas) checkTopBottomCollision() {
-	if c.bll == nil {
-		return
-	}
-
-	if c.bll.yPos <= c.bll.radius || c.bll.yPos >= c.canvasEl.Height-c.bll.radius {
-		c.bll.yMovement *= -1
+func (c *canvas) checkTopBottomCollision(b *ball) {
+	if b.bounce(c.view.height) {
+		c.sounds.play("wall")
 	}
 }
 
-func (c *canvas) checkPaddleCollision() {
-	if c.bll == nil {
+func (c *canvas) checkPaddleCollision(id int, b *ball) {
+	if c.pddl == nil {
 		return
 	}
 
-	// If the ball is in the vicinity of where the paddle could be then do some more fancy collision detection.
-	// First check to see if the ball already hit the paddle.
-	if (!c.pddl.hit) && ((c.side == "LEFT" && c.bll.xPos < (c.bll.radius+c.pddl.xPos+c.pddl.width+10)) ||
-		(c.side == "RIGHT" && c.bll.xPos > (c.pddl.xPos-c.bll.radius-10))) {
+	// a ball only bounces off a paddle once on its way to the end
+	if b.hit {
+		return
+	}
 
-		detectionArea := int(float64(c.bll.radius) * float64(1.5))
-		var m int
-		if c.side == "LEFT" {
-			m = -1
-		} else {
-			m = 1
+	paddles := []*paddle{c.pddl}
+	for _, mate := range c.mates {
+		paddles = append(paddles, mate)
+	}
+	for _, p := range paddles {
+		if p.touches(b) {
+			b.yMovement += float64(rand.Intn(3) - 1)
+			c.returnBall(id, b)
+			return
 		}
-		whatColor := getImageData(c.canvasEl.GetContext2d(), c.bll.xPos+(c.bll.radius*m), c.bll.yPos+(c.bll.radius*m), detectionArea, detectionArea)
-		if whatColor.anyBlue() {
-			c.bll.xMovement *= -1
-			c.bll.yMovement += float64(rand.Intn(3) - 1)
-			c.pddl.hit = true
+	}
+}
+
+// returnBall sends a ball back towards the net and tells the server about it as a hit.
+func (c *canvas) returnBall(id int, b *ball) {
+	b.xMovement *= -1
+	b.hit = true
+	c.sounds.play("hit")
+
+	deg, speed := b.vector()
+	c.event <- fmt.Sprintf("H,%d,%d,%d,%d,%d", b.xPos, b.yPos, deg, speed, id)
+}
+
+// checkPowerUpCollision collects any power-up the ball hits. It's gone from this screen straight away, the
+// server says what it does.
+func (c *canvas) checkPowerUpCollision(id int, b *ball) {
+	for puID, u := range c.powerUps {
+		if u.collects(b) {
+			delete(c.powerUps, puID)
+			c.event <- fmt.Sprintf("C,%d,%d", puID, id)
 		}
 	}
 }
 
-func (c *canvas) checkOverNet() bool {
-	if c.bll == nil {
-		return false
+func (c *canvas) checkOverNet(b *ball) bool {
+	if c.display {
+		// displays pass the ball on whichever way it leaves
+		return b.xPos > c.view.width || b.xPos < 0
 	}
-	return (c.side == "LEFT" && (c.bll.xPos > c.canvasEl.Width)) || (c.side == "RIGHT" && (c.bll.xPos < 0))
+	return (c.side == "LEFT" && (b.xPos > c.view.width)) || (c.side == "RIGHT" && (b.xPos < 0))
 }
 
-func (c *canvas) reset(side string) {
+// reset sets the canvas up for playing on side, in lane of the side's lanes. Any other lanes have
+// teammates' paddles in them.
+func (c *canvas) reset(side string, lane int, lanes int) {
+	c.stopLocal()
+	c.paused = false
 	c.side = side
+	c.display = false
+
+	c.pddl = newPaddle(side, lane, lanes, c.view.width, c.view.height)
+	c.mates = make(map[int]*paddle)
+	for l := 0; l < lanes; l++ {
+		if l != lane {
+			c.mates[l] = newPaddle(side, l, lanes, c.view.width, c.view.height)
+		}
+	}
+	c.balls = make(map[int]*ball)
+	c.powerUps = make(map[int]*powerUp)
+	c.effects = make(map[string]time.Time)
+	c.board = nil
+}
+
+// setBoard sets up the obstacles on the court, nil for an empty court.
+func (c *canvas) setBoard(b *board) {
+	c.board = b
+	c.started = time.Now()
+}
+
+// frames returns the number of animation frames since the board was set up.
+func (c *canvas) frames() int {
+	return int(time.Since(c.started) / (time.Second / time.Duration(animationFramesPerSecond)))
+}
+
+// showDisplay sets the canvas up as a display only segment of the court, with no paddles.
+func (c *canvas) showDisplay() {
+	c.stopLocal()
+	c.paused = false
+	c.side = ""
+	c.display = true
+	c.pddl = nil
+	c.mates = nil
+	c.balls = make(map[int]*ball)
+	c.powerUps = make(map[int]*powerUp)
+	c.effects = make(map[string]time.Time)
+	c.board = nil
+}
+
+// startLocal starts a game in the browser between two players, replacing any local game already going. The
+// canvas is widened to hold the whole court.
+func (c *canvas) startLocal(left localPlayer, right localPlayer) {
+	if c.local == nil {
+		c.view.resize(2*courtWidth, courtHeight)
+	}
+	c.local = newLocalGame(c.view.width, c.view.height, left, right)
+	c.local.sounds = c.sounds
+	c.local.theme = c.theme
+	c.canvasEl.Focus()
+}
+
+// setTheme changes how the court looks.
+func (c *canvas) setTheme(th *theme) {
+	c.theme = th
+	if c.local != nil {
+		c.local.theme = th
+	}
+}
+
+// stopLocal ends any local game and puts the canvas back to its online size.
+func (c *canvas) stopLocal() {
+	if c.local == nil {
+		return
+	}
+	c.local = nil
+	c.view.resize(courtWidth, courtHeight)
+}
 
-	var xPos int
-	var offsetFromEnd = 10
-	var paddleWidth = 20
+// addPowerUp puts a power-up on the court.
+func (c *canvas) addPowerUp(id int, kind string, xPos int, yPos int) {
+	c.powerUps[id] = &powerUp{kind: kind, xPos: xPos, yPos: yPos}
+}
 
+// removePowerUp takes a power-up off the court, it has been collected or expired.
+func (c *canvas) removePowerUp(id int) {
+	delete(c.powerUps, id)
+}
+
+// applyEffect starts a power-up's effect on this player, it wears off after duration.
+func (c *canvas) applyEffect(kind string, duration time.Duration) {
+	c.effects[kind] = time.Now().Add(duration)
+}
+
+// active returns true if a power-up's effect is on this player.
+func (c *canvas) active(kind string) bool {
+	until, ok := c.effects[kind]
+	return ok && time.Now().Before(until)
+}
+
+// paddleHeight returns the height of the paddle with any power-up effects.
+func (c *canvas) paddleHeight() int {
+	height := paddleHeight
+	if c.active("grow") {
+		height = height * 3 / 2
+	}
+	if c.active("shrink") {
+		height = height * 2 / 3
+	}
+	if max := c.pddl.bottom - c.pddl.top - 20; height > max {
+		height = max
+	}
+	return height
+}
+
+// mateMoved moves a teammate's paddle. The ball bounces off teammates' paddles in the same way as ours.
+func (c *canvas) mateMoved(lane int, yPos int, yMovement int) {
+	if mate, ok := c.mates[lane]; ok {
+		mate.yPos = yPos
+		mate.yMovement = yMovement
+	}
+}
+
+// ballSync puts a ball where a teammate's screen says it is after they hit it.
+func (c *canvas) ballSync(id int, xPos int, v *vector) {
+	radians := v.angle * degreeToRadian
+
+	c.balls[id] = &ball{xPos: xPos, yPos: v.yPos, radius: ballRadius,
+		xMovement: math.Cos(radians) * v.speed, yMovement: math.Sin(radians) * v.speed, hit: true}
+}
+
+// paddleXPos returns where the paddle goes on a side's half of the court.
+func paddleXPos(side string, width int) int {
 	if side == "LEFT" {
-		xPos = offsetFromEnd
-	} else {
-		xPos = c.canvasEl.Width - offsetFromEnd - paddleWidth
+		return paddleOffsetFromEnd
 	}
+	return width - paddleOffsetFromEnd - paddleWidth
+}
 
-	c.pddl = &paddle{xPos: xPos, yPos: 350, height: 150, width: paddleWidth}
-	c.bll = nil
+// entryXPos returns where a ball moving across by xMovement comes onto a screen width wide, at the edge it is
+// moving away from. Balls always reach the LEFT side moving left and the RIGHT side moving right.
+func entryXPos(xMovement float64, width int) int {
+	if xMovement < 0 {
+		return width - 5
+	}
+	return 5
 }
@@ -3,7 +3,9 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,11 +14,16 @@ import (
 	"honnef.co/go/js/dom"
 )
 
+// announceTime is how long news, like a badge earned, is shown in place of the status.
+const announceTime = time.Duration(4) * time.Second
+
 type gateway struct {
 	conn     *websocket.Conn
 	send     chan string
 	statusEl dom.HTMLElement
 	canvas   *canvas
+	online   bool   // in a match as a player or a display, rather than waiting or playing locally
+	status   string // shown again once a pause is over
 }
 
 func newGateway() *gateway {
@@ -28,13 +35,21 @@ func newGateway() *gateway {
 
 	canvas := newCanvas(doc.GetElementByID("board").(*dom.HTMLCanvasElement))
 
-	statusEl.SetTextContent("Connecting")
-	conn := connect(wsEndpoint)
-	statusEl.SetTextContent("Waiting To Play")
+	gw := &gateway{send: make(chan string), statusEl: statusEl, canvas: canvas}
+	gw.setUpLocalPlay(doc)
+	gw.setUpRematch(doc)
+	canvas.sounds.bindControls(doc)
+	canvas.setTheme(chooseTheme(doc))
+	bindThemeControl(doc, canvas.setTheme)
 
-	wsSend := make(chan string)
+	// play the computer until there's a match to play
+	gw.playComputer()
+
+	gw.setStatus("Connecting")
+	conn := connect(wsEndpoint)
+	gw.setStatus("Waiting To Play")
 
-	gw := &gateway{conn: conn, send: wsSend, statusEl: statusEl, canvas: canvas}
+	gw.conn = conn
 
 	// start send loop (send over websocket to server)
 	go func(s chan string) {
@@ -46,7 +61,7 @@ func newGateway() *gateway {
 				console.Error(err.Error())
 			}
 		}
-	}(wsSend)
+	}(gw.send)
 
 	// listen for canvas events
 	go func() {
@@ -55,9 +70,13 @@ func newGateway() *gateway {
 
 			parts := strings.Split(e, ",")
 			if parts[0] == "L" {
-				gw.processLostEvent()
+				gw.processLostEvent(e)
 			} else if parts[0] == "N" {
 				gw.processNetExchangeEvent(e)
+			} else if parts[0] == "M" || parts[0] == "H" || parts[0] == "C" || parts[0] == "Z" || parts[0] == "R" {
+				// paddle movement and hits are only of interest to the match recording, the server
+				// decides what collected power-ups do
+				gw.send <- e
 			} else {
 				console.Log(fmt.Sprintf("unsupported event: %s\n", e))
 			}
@@ -82,47 +101,284 @@ func (g *gateway) start() {
 func (g *gateway) handleMessage(msg []byte) {
 	m := string(msg)
 
+	if strings.HasPrefix(m, "G,") {
+		// the board of the match about to start, JSON rather than comma separated
+		g.handleBoardMessage(m[2:])
+		return
+	}
+
 	parts := strings.Split(m, ",")
 
 	if parts[0] == "P" {
-		g.handlePlayMessage(parts[1])
+		// 1 = side
+		// 2 = lane, 3 = lanes, only sent when the side has more than one paddle
+		lane, lanes := 0, 1
+		if len(parts) >= 4 {
+			lane, _ = strconv.Atoi(parts[2])
+			lanes, _ = strconv.Atoi(parts[3])
+		}
+		g.handlePlayMessage(parts[1], lane, lanes)
+	} else if parts[0] == "D" {
+		// this screen is a display only segment of the court
+		// 1 = segment, 2 = segments
+		segment, _ := strconv.Atoi(parts[1])
+		segments, _ := strconv.Atoi(parts[2])
+		g.handleDisplayMessage(segment, segments)
+	} else if parts[0] == "U" {
+		// a power-up appeared
+		// 1 = id, 2 = kind, 3 = x position, 4 = y position
+		id, _ := strconv.Atoi(parts[1])
+		xPos, _ := strconv.Atoi(parts[3])
+		yPos, _ := strconv.Atoi(parts[4])
+		g.canvas.addPowerUp(id, parts[2], xPos, yPos)
+	} else if parts[0] == "X" {
+		// a power-up was collected or expired
+		// 1 = id
+		id, _ := strconv.Atoi(parts[1])
+		g.canvas.removePowerUp(id)
+	} else if parts[0] == "E" {
+		// a power-up's effect applies to this player
+		// 1 = kind, 2 = duration in milliseconds
+		millis, _ := strconv.Atoi(parts[2])
+		g.canvas.applyEffect(parts[1], time.Duration(millis)*time.Millisecond)
+	} else if parts[0] == "Z" {
+		// the match is paused
+		// 1 = the side that paused, 2 = pauses they have left, 3 = how long the pause lasts at most in milliseconds
+		left, _ := strconv.Atoi(parts[2])
+		millis, _ := strconv.Atoi(parts[3])
+		g.statusEl.SetTextContent(fmt.Sprintf("Paused by %s (%d left), P to resume, or in %ds", parts[1], left,
+			millis/1000))
+		g.canvas.pause()
+	} else if parts[0] == "R" {
+		// the match resumes
+		// 1 = milliseconds until it does
+		millis, _ := strconv.Atoi(parts[1])
+		g.statusEl.SetTextContent(g.status)
+		g.canvas.resumeIn(time.Duration(millis) * time.Millisecond)
+	} else if parts[0] == "K" {
+		// this player earned a badge
+		// 1 = achievement id, 2 = its name, which may have commas in it
+		g.announce("Achievement: " + strings.Join(parts[2:], ","))
+	} else if parts[0] == "W" {
+		// this player won but leaves the court for the wait list
+		g.processRotateEvent()
+	} else if parts[0] == "Q" {
+		// a rematch is offered, or withdrawn
+		// 1 = milliseconds to accept it in, 0 when withdrawn
+		millis, _ := strconv.Atoi(parts[1])
+		g.showRematchOffer(time.Duration(millis) * time.Millisecond)
+	} else if parts[0] == "O" {
+		// a teammate's paddle moved
+		// 1 = lane, 2 = y position, 3 = movement
+		lane, _ := strconv.Atoi(parts[1])
+		yPos, _ := strconv.Atoi(parts[2])
+		move, _ := strconv.Atoi(parts[3])
+		g.canvas.mateMoved(lane, yPos, move)
+	} else if parts[0] == "S" {
+		// a teammate hit a ball
+		// 1 = x position, 2 = y position, 3 = angle, 4 = speed, 5 = ball id
+		xPos, _ := strconv.Atoi(parts[1])
+		v, err := newVectorFromStrings(parts[2], parts[3], parts[4])
+		if err != nil {
+			console.Log(err.Error())
+			return
+		}
+		g.canvas.ballSync(ballID(parts, 5), xPos, v)
 	} else if parts[0] == "B" {
 		// 1 = y position
 		// 2 = angle
 		// 3 = speed
+		// 4 = ball id
 		v, err := newVectorFromStrings(parts[1], parts[2], parts[3])
 		if err != nil {
 			console.Log(err.Error())
 		}
 
-		g.handleBallInPlayMessage(v)
+		g.handleBallInPlayMessage(ballID(parts, 4), v)
 	} else {
 		console.Log(fmt.Sprintf("unsupported message: %s\n", m))
 	}
 }
 
-func (g *gateway) handlePlayMessage(side string) {
+func (g *gateway) handlePlayMessage(side string, lane int, lanes int) {
 	dSide := strings.ToUpper(side)
 
-	console.Log(fmt.Sprintf("handling play message - side: %s\n", dSide))
+	console.Log(fmt.Sprintf("handling play message - side: %s, lane %d of %d\n", dSide, lane, lanes))
+
+	if lanes > 1 {
+		g.setStatus(fmt.Sprintf("Playing (%s, lane %d of %d)", dSide, lane+1, lanes))
+	} else {
+		g.setStatus("Playing (" + dSide + ")")
+	}
+
+	g.online = true
+	g.showRematchOffer(0)
+	g.canvas.reset(dSide, lane, lanes)
+	g.canvas.sounds.play("turn")
+}
+
+func (g *gateway) handleBoardMessage(data string) {
+	b := &board{}
+	if err := json.Unmarshal([]byte(data), b); err != nil {
+		console.Error(err.Error())
+		return
+	}
+
+	console.Log(fmt.Sprintf("handling board message - board: %q\n", b.Name))
+
+	if b.Name == "" && len(b.Obstacles) == 0 && b.Goal == nil {
+		b = nil
+	}
+	g.canvas.setBoard(b)
+}
 
-	g.statusEl.SetTextContent("Playing (" + dSide + ")")
+func (g *gateway) handleDisplayMessage(segment int, segments int) {
+	console.Log(fmt.Sprintf("handling display message - segment %d of %d\n", segment, segments))
 
-	g.canvas.reset(dSide)
+	g.setStatus(fmt.Sprintf("Display (%d of %d)", segment+1, segments))
+
+	g.online = true
+	g.canvas.showDisplay()
 }
 
-func (g *gateway) handleBallInPlayMessage(v *vector) {
-	console.Log(fmt.Sprintf("handling ball in play message - y pos: %d, angle: %v, speed: %v\n", v.yPos, v.angle, v.speed))
+func (g *gateway) handleBallInPlayMessage(id int, v *vector) {
+	console.Log(fmt.Sprintf("handling ball in play message - ball: %d, y pos: %d, angle: %v, speed: %v\n", id, v.yPos, v.angle, v.speed))
 
 	// Note angle is always specified as if for the LEFT side player, the RIGHT side player
 	// will do the inverse.
 
-	g.canvas.ballStart(v)
+	g.canvas.ballStart(id, v)
+	g.canvas.sounds.play("serve")
+}
+
+func (g *gateway) processLostEvent(eventMsg string) {
+	g.setStatus("Lost - Waiting To Play")
+	g.canvas.sounds.play("end")
+	g.send <- eventMsg
+
+	g.online = false
+	g.playComputer()
+}
+
+func (g *gateway) processRotateEvent() {
+	g.setStatus("Won - Waiting To Play")
+	g.canvas.sounds.play("end")
+
+	g.online = false
+	g.playComputer()
+}
+
+// announce shows news in place of the status for a few seconds.
+func (g *gateway) announce(news string) {
+	g.statusEl.SetTextContent(news)
+	g.canvas.sounds.play("score")
+
+	go func() {
+		time.Sleep(announceTime)
+		if g.statusEl.TextContent() == news {
+			g.statusEl.SetTextContent(g.status)
+		}
+	}()
+}
+
+// setStatus shows what the player is doing.
+func (g *gateway) setStatus(status string) {
+	g.status = status
+	g.statusEl.SetTextContent(status)
+}
+
+// setUpRematch wires up the page's rematch offer, if it has one.
+func (g *gateway) setUpRematch(doc dom.Document) {
+	answer := func(id string, msg string) {
+		if button := doc.GetElementByID(id); button != nil {
+			button.AddEventListener("click", false, func(event dom.Event) {
+				event.PreventDefault()
+				g.showRematchOffer(0)
+				go func() {
+					g.send <- msg
+				}()
+			})
+		}
+	}
+	answer("rematch-accept", "A,1")
+	answer("rematch-decline", "A,0")
+}
+
+// showRematchOffer shows the rematch offer for timeout, a timeout of 0 hides it.
+func (g *gateway) showRematchOffer(timeout time.Duration) {
+	offer, ok := dom.GetWindow().Document().GetElementByID("rematch").(dom.HTMLElement)
+	if !ok {
+		return
+	}
+
+	if timeout <= 0 {
+		offer.SetAttribute("hidden", "")
+		return
+	}
+
+	offer.RemoveAttribute("hidden")
+	g.canvas.sounds.play("turn")
+	time.AfterFunc(timeout, func() {
+		offer.SetAttribute("hidden", "")
+	})
 }
 
-func (g *gateway) processLostEvent() {
-	g.statusEl.SetTextContent("Lost - Waiting To Play")
-	g.send <- "L"
+// setUpLocalPlay wires up the controls for playing in the browser, if the page has them.
+func (g *gateway) setUpLocalPlay(doc dom.Document) {
+	if button := doc.GetElementByID("local-play"); button != nil {
+		button.AddEventListener("click", false, func(event dom.Event) {
+			event.PreventDefault()
+			g.playComputer()
+		})
+	}
+	if button := doc.GetElementByID("local-versus"); button != nil {
+		button.AddEventListener("click", false, func(event dom.Event) {
+			event.PreventDefault()
+			g.playHotSeat()
+		})
+	}
+	if sel := doc.GetElementByID("local-difficulty"); sel != nil {
+		sel.AddEventListener("change", false, func(event dom.Event) {
+			g.playComputer()
+		})
+	}
+}
+
+// playComputer starts a game against the computer at the difficulty picked on the page, unless there's a match
+// to play online. Up and down, or W and S, move the paddle.
+func (g *gateway) playComputer() {
+	if g.online {
+		return
+	}
+
+	difficulty := "normal"
+	if sel, ok := dom.GetWindow().Document().GetElementByID("local-difficulty").(*dom.HTMLSelectElement); ok {
+		difficulty = sel.Value
+	}
+
+	you := &keyPlayer{label: "You", upKeys: []string{"Up", "W"}, downKeys: []string{"Down", "S"}}
+	g.canvas.startLocal(you, newComputerPlayer(difficulty))
+}
+
+// playHotSeat starts a game between two people at the one keyboard, unless there's a match to play online.
+// W and S move the LEFT paddle, up and down the RIGHT.
+func (g *gateway) playHotSeat() {
+	if g.online {
+		return
+	}
+
+	left := &keyPlayer{label: "W/S", upKeys: []string{"W"}, downKeys: []string{"S"}}
+	right := &keyPlayer{label: "↑/↓", upKeys: []string{"Up"}, downKeys: []string{"Down"}}
+	g.canvas.startLocal(left, right)
+}
+
+// ballID returns the ball id in field i of a message, 0 for messages from before balls had ids.
+func ballID(parts []string, i int) int {
+	if len(parts) <= i {
+		return 0
+	}
+	id, _ := strconv.Atoi(parts[i])
+	return id
 }
 
 func (g *gateway) processNetExchangeEvent(eventMsg string) {
@@ -0,0 +1,270 @@
+// +build js
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"honnef.co/go/js/dom"
+)
+
+const (
+	// frames between a point being won and the next serve
+	localServeDelay = animationFramesPerSecond
+	localServeSpeed = 4
+)
+
+// localPlayer controls one of the paddles in a local game.
+type localPlayer interface {
+	// control sets the paddle's movement for the next frame.
+	control(p *paddle, g *localGame)
+	// key tells the player a key has gone down or up.
+	key(name string, down bool)
+	// name is shown with the score.
+	name() string
+}
+
+// localGame is a game played entirely in the browser, with no server. Both halves of the court are drawn side by
+// side on one canvas and the ball bounces off the paddles by where they are rather than by colour.
+type localGame struct {
+	width    int
+	height   int
+	bll      *ball
+	paddles  [2]*paddle // LEFT and RIGHT
+	players  [2]localPlayer
+	scores   [2]int
+	serveIn  int // frames until the next serve
+	serveTo  int // the side the next serve goes to
+	hitCount int
+	sounds   *sounds
+	theme    *theme
+}
+
+// newLocalGame creates a local game on a court width by height with a player for each side.
+func newLocalGame(width int, height int, leftPlayer localPlayer, rightPlayer localPlayer) *localGame {
+	return &localGame{
+		width:   width,
+		height:  height,
+		paddles: [2]*paddle{newPaddle("LEFT", 0, 1, width, height), newPaddle("RIGHT", 0, 1, width, height)},
+		players: [2]localPlayer{leftPlayer, rightPlayer},
+		serveIn: localServeDelay,
+	}
+}
+
+// key passes a key press on to the players.
+func (g *localGame) key(name string, down bool) {
+	for _, pl := range g.players {
+		pl.key(name, down)
+	}
+}
+
+// step moves the game on by one animation frame and draws it.
+func (g *localGame) step(ctx *dom.CanvasRenderingContext2D) {
+	for i, pl := range g.players {
+		pl.control(g.paddles[i], g)
+		g.paddles[i].move()
+	}
+
+	if g.bll == nil {
+		if g.serveIn--; g.serveIn <= 0 {
+			g.serve()
+		}
+	} else {
+		g.moveBall()
+	}
+
+	g.render(ctx)
+}
+
+// serve puts a ball in play from the middle of the court towards serveTo, as the server does.
+func (g *localGame) serve() {
+	angle := float64(rand.Intn(90) + 135)
+	if g.serveTo == 1 {
+		angle = 180 - angle
+	}
+	radians := angle * degreeToRadian
+
+	g.sounds.play("serve")
+	g.bll = &ball{
+		xPos:      g.width / 2,
+		yPos:      rand.Intn(g.height-200) + 100,
+		radius:    ballRadius,
+		xMovement: math.Cos(radians) * localServeSpeed,
+		yMovement: math.Sin(radians) * localServeSpeed,
+	}
+}
+
+func (g *localGame) moveBall() {
+	b := g.bll
+	b.move()
+	if b.bounce(g.height) {
+		g.sounds.play("wall")
+	}
+
+	for _, p := range g.paddles {
+		before := b.xMovement
+		if bounceOffRect(b, p.xPos, p.yPos, p.width, p.height) && before != b.xMovement {
+			// the same nudge as the online game, and a little faster every few hits
+			g.sounds.play("hit")
+			b.yMovement += float64(rand.Intn(3) - 1)
+			if g.hitCount++; g.hitCount%4 == 0 && math.Abs(b.xMovement) < 12 {
+				b.xMovement *= 1.1
+			}
+		}
+	}
+
+	if b.xPos < 0 {
+		g.point(1)
+	} else if b.xPos > g.width {
+		g.point(0)
+	}
+}
+
+// point gives a point to a side and serves the next ball to the other side.
+func (g *localGame) point(side int) {
+	g.scores[side]++
+	g.sounds.play("score")
+	g.bll = nil
+	g.hitCount = 0
+	g.serveTo = 1 - side
+	g.serveIn = localServeDelay
+}
+
+func (g *localGame) render(ctx *dom.CanvasRenderingContext2D) {
+	g.theme.clear(ctx, g.width, g.height)
+
+	// the net
+	ctx.FillStyle = g.theme.lines
+	ctx.FillRect(g.width/2-1, 0, 2, g.height)
+
+	ctx.FillStyle = g.theme.text
+	ctx.Font = "48px sans-serif"
+	ctx.TextAlign = "center"
+	ctx.TextBaseline = "top"
+	ctx.FillText(fmt.Sprintf("%s  %d : %d  %s", g.players[0].name(), g.scores[0], g.scores[1], g.players[1].name()),
+		g.width/2, 20, -1)
+
+	for _, p := range g.paddles {
+		p.render(ctx, g.theme, g.theme.paddle)
+	}
+	if g.bll != nil {
+		g.bll.render(ctx, g.theme)
+	}
+}
+
+// keyPlayer is someone at the keyboard.
+type keyPlayer struct {
+	label    string
+	upKeys   []string
+	downKeys []string
+	up       bool
+	down     bool
+}
+
+func (k *keyPlayer) control(p *paddle, g *localGame) {
+	p.yMovement = 0
+	if k.up {
+		p.yMovement -= paddleSpeed
+	}
+	if k.down {
+		p.yMovement += paddleSpeed
+	}
+}
+
+func (k *keyPlayer) key(name string, down bool) {
+	for _, up := range k.upKeys {
+		if name == up {
+			k.up = down
+		}
+	}
+	for _, dn := range k.downKeys {
+		if name == dn {
+			k.down = down
+		}
+	}
+}
+
+func (k *keyPlayer) name() string {
+	return k.label
+}
+
+// computerPlayer moves its paddle towards the ball. How well it plays depends on its difficulty.
+type computerPlayer struct {
+	difficulty string
+	speed      int // how fast the paddle moves
+	reach      int // how far away from its end of the court the ball has to be before it follows it
+	aimError   int // how far from the middle of the paddle it might try to hit the ball
+	aim        int
+}
+
+// computerDifficulties are the available difficulties by name.
+var computerDifficulties = map[string]computerPlayer{
+	"easy":   {difficulty: "easy", speed: 2, reach: 700, aimError: 70},
+	"normal": {difficulty: "normal", speed: 3, reach: 1300, aimError: 40},
+	"hard":   {difficulty: "hard", speed: 5, reach: 2600, aimError: 15},
+}
+
+// newComputerPlayer returns a computer player of the named difficulty, normal if there's no such difficulty.
+func newComputerPlayer(difficulty string) *computerPlayer {
+	cp, ok := computerDifficulties[difficulty]
+	if !ok {
+		cp = computerDifficulties["normal"]
+	}
+	return &cp
+}
+
+func (cp *computerPlayer) control(p *paddle, g *localGame) {
+	target := g.height / 2
+
+	if b := g.bll; b != nil {
+		towards := (p.xPos > g.width/2) == (b.xMovement > 0)
+		distance := int(math.Abs(float64(p.xPos - b.xPos)))
+		if towards && distance < cp.reach {
+			target = b.yPos + cp.aim
+		} else if !towards {
+			// pick where to aim for the next time the ball comes back
+			cp.aim = rand.Intn(2*cp.aimError+1) - cp.aimError
+		}
+	}
+
+	middle := p.yPos + p.height/2
+	switch {
+	case target < middle-cp.speed:
+		p.yMovement = -cp.speed
+	case target > middle+cp.speed:
+		p.yMovement = cp.speed
+	default:
+		p.yMovement = 0
+	}
+}
+
+func (cp *computerPlayer) key(name string, down bool) {
+}
+
+func (cp *computerPlayer) name() string {
+	return "Computer (" + cp.difficulty + ")"
+}
+
+// keyName returns the name of the key of a keyboard event, one of Up, Down, W, S and P for the keys the game uses.
+// Older browsers only set the key identifier, newer ones only the key code.
+func keyName(e *dom.KeyboardEvent) string {
+	if e.KeyIdentifier == "Up" || e.KeyIdentifier == "Down" {
+		return e.KeyIdentifier
+	}
+
+	switch e.KeyCode {
+	case 38:
+		return "Up"
+	case 40:
+		return "Down"
+	case 87:
+		return "W"
+	case 83:
+		return "S"
+	case 80:
+		return "P"
+	}
+	return ""
+}
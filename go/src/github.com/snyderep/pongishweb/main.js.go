@@ -2,7 +2,22 @@
 
 package main
 
+import "honnef.co/go/js/dom"
+
 func main() {
+	doc := dom.GetWindow().Document()
+
+	// the replay page plays back a recorded match rather than connecting to the server
+	if doc.GetElementByID("replay-board") != nil {
+		go startReplay(doc)
+		return
+	}
+
+	// pages without a court, like the leaderboard, have nothing to play
+	if doc.GetElementByID("board") == nil {
+		return
+	}
+
 	// start the gateway, start listening on the websocket and handling events
 	g := newGateway()
 	go g.start()
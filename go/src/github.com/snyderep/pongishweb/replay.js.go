@@ -0,0 +1,314 @@
+// +build js
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"honnef.co/go/js/console"
+	"honnef.co/go/js/dom"
+)
+
+const frameMillis = float64(1000 / animationFramesPerSecond)
+
+// replayEvent is an event in a recorded match, see matchEvent in the server.
+type replayEvent struct {
+	T      float64      `json:"t"`
+	Kind   string       `json:"kind"`
+	Seat   int          `json:"seat"`
+	Side   string       `json:"side"`
+	Lane   int          `json:"lane"`
+	Ball   int          `json:"ball"`
+	X      int          `json:"x"`
+	Y      int          `json:"y"`
+	Angle  int          `json:"angle"`
+	Speed  int          `json:"speed"`
+	Move   int          `json:"move"`
+	Reason string       `json:"reason"`
+	Seats  []replaySeat `json:"seats"`
+	Board  *board       `json:"board"`
+}
+
+// replaySeat is a seat in the court's layout, see seatT in the server.
+type replaySeat struct {
+	Side   string `json:"side"`
+	Lane   int    `json:"lane"`
+	Lanes  int    `json:"lanes"`
+	Screen int    `json:"screen"`
+}
+
+// classicSeats is the layout of recordings made before layouts were recorded.
+var classicSeats = []replaySeat{{Side: "LEFT", Lanes: 1, Screen: 0}, {Side: "RIGHT", Lanes: 1, Screen: 1}}
+
+// replay plays back a recorded match on a canvas showing every player's screen side by side.
+// The ball and paddles are simulated the same way the players' screens move them, starting from
+// the most recent recorded event.
+type replay struct {
+	canvasEl *dom.HTMLCanvasElement
+	view     *viewport
+	events   []replayEvent
+	seats    []replaySeat
+	board    *board
+	theme    *theme
+	screens  int
+	duration float64 // ms
+	pos      float64 // ms
+	speed    float64
+	paused   bool
+	playEl   *dom.HTMLButtonElement
+	seekEl   *dom.HTMLInputElement
+	timeEl   dom.HTMLElement
+}
+
+func newReplay(doc dom.Document) (*replay, error) {
+	var events []replayEvent
+	data := doc.GetElementByID("replay-events").(dom.HTMLElement).TextContent()
+	if err := json.Unmarshal([]byte(data), &events); err != nil {
+		return nil, err
+	}
+
+	r := &replay{
+		canvasEl: doc.GetElementByID("replay-board").(*dom.HTMLCanvasElement),
+		events:   events,
+		speed:    1,
+		theme:    chooseTheme(doc),
+		playEl:   doc.GetElementByID("replay-play").(*dom.HTMLButtonElement),
+		seekEl:   doc.GetElementByID("replay-seek").(*dom.HTMLInputElement),
+		timeEl:   doc.GetElementByID("replay-time").(dom.HTMLElement),
+	}
+	if len(events) > 0 {
+		r.duration = events[len(events)-1].T
+		r.seats = events[0].Seats
+		r.board = events[0].Board
+	}
+	if len(r.seats) == 0 {
+		r.seats = classicSeats
+		for i := range r.events {
+			if r.events[i].Side == "RIGHT" {
+				r.events[i].Seat = 1
+			}
+		}
+	}
+	for _, seat := range r.seats {
+		if seat.Screen+1 > r.screens {
+			r.screens = seat.Screen + 1
+		}
+	}
+	r.view = newViewport(r.canvasEl, r.screens*courtWidth, courtHeight)
+	r.seekEl.Max = strconv.Itoa(int(r.duration))
+
+	r.playEl.AddEventListener("click", false, func(dom.Event) {
+		if r.paused && r.pos >= r.duration {
+			r.pos = 0
+		}
+		r.setPaused(!r.paused)
+	})
+	r.seekEl.AddEventListener("input", false, func(dom.Event) {
+		r.pos = r.seekEl.ValueAsNumber
+	})
+	speedEl := doc.GetElementByID("replay-speed").(*dom.HTMLSelectElement)
+	speedEl.AddEventListener("change", false, func(dom.Event) {
+		if speed, err := strconv.ParseFloat(speedEl.Value, 64); err == nil {
+			r.speed = speed
+		}
+	})
+
+	return r, nil
+}
+
+func (r *replay) start() {
+	ticker := time.NewTicker(time.Duration(frameMillis) * time.Millisecond)
+	for {
+		<-ticker.C
+
+		if !r.paused {
+			r.pos += frameMillis * r.speed
+			if r.pos >= r.duration {
+				r.pos = r.duration
+				r.setPaused(true)
+			}
+			r.seekEl.Value = strconv.Itoa(int(r.pos))
+		}
+
+		r.timeEl.SetTextContent(fmt.Sprintf("%.1fs / %.1fs", r.pos/1000, r.duration/1000))
+		r.draw()
+	}
+}
+
+func (r *replay) setPaused(paused bool) {
+	r.paused = paused
+	if paused {
+		r.playEl.SetTextContent("Play")
+	} else {
+		r.playEl.SetTextContent("Pause")
+	}
+}
+
+func (r *replay) draw() {
+	ctx := r.view.context()
+	r.theme.clear(ctx, r.view.width, r.view.height)
+
+	// the edges between screens
+	ctx.FillStyle = r.theme.lines
+	for screen := 1; screen < r.screens; screen++ {
+		ctx.FillRect(screen*courtWidth-1, 0, 2, courtHeight)
+	}
+
+	frames := int(r.pos / frameMillis)
+	for screen := 0; screen < r.screens; screen++ {
+		r.board.render(ctx, r.theme, r.screenSide(screen), courtWidth, courtHeight, screen*courtWidth, frames)
+	}
+
+	for seat := range r.seats {
+		p := r.paddleAt(seat, r.pos)
+		p.xPos += r.seats[seat].Screen * courtWidth
+		p.render(ctx, r.theme, r.theme.paddle)
+	}
+
+	for _, b := range r.ballsAt(r.pos) {
+		b.render(ctx, r.theme)
+	}
+}
+
+// ballsAt works out where the balls on the court are at t ms into the match.
+func (r *replay) ballsAt(t float64) []*ball {
+	from := make(map[int]*replayEvent) // the most recent event about each ball
+	for i := range r.events {
+		e := &r.events[i]
+		if e.T > t {
+			break
+		}
+		switch e.Kind {
+		case "serve", "handoff", "hit":
+			from[e.Ball] = e
+		case "lost":
+			// the loser is off the court along with every ball on their screen
+			for id, f := range from {
+				if f.Seat == e.Seat {
+					delete(from, id)
+				}
+			}
+		case "end":
+			from = make(map[int]*replayEvent)
+		}
+	}
+
+	var balls []*ball
+	for _, e := range from {
+		if b := r.ballFrom(e, t); b != nil {
+			balls = append(balls, b)
+		}
+	}
+
+	return balls
+}
+
+// ballFrom works out where a ball is at t ms into the match from the most recent event about it, nil if it has
+// gone off the screen that event put it on.
+func (r *replay) ballFrom(from *replayEvent, t float64) *ball {
+	if from.Seat >= len(r.seats) {
+		return nil
+	}
+
+	radians := float64(from.Angle) * degreeToRadian
+	b := &ball{
+		xPos:      from.X,
+		yPos:      from.Y,
+		radius:    ballRadius,
+		xMovement: math.Cos(radians) * float64(from.Speed),
+		yMovement: math.Sin(radians) * float64(from.Speed),
+	}
+	if from.Kind != "hit" {
+		// the ball comes onto the screen at the edge it is moving away from, as in canvas.ballStart
+		b.xPos = entryXPos(b.xMovement, courtWidth)
+	}
+
+	side := r.seats[from.Seat].Side
+	start := int(from.T / frameMillis)
+	for frame := 0; frame < int((t-from.T-r.pausedBetween(from.T, t))/frameMillis); frame++ {
+		b.move()
+		if b.xPos < 0 || b.xPos > courtWidth {
+			// gone off the screen, the next event says where
+			return nil
+		}
+		b.bounce(courtHeight)
+		r.board.collide(b, side, courtWidth, start+frame)
+	}
+
+	b.xPos += r.seats[from.Seat].Screen * courtWidth
+
+	return b
+}
+
+// pausedBetween returns how many of the ms between from and to the match was paused for, when nothing moved.
+func (r *replay) pausedBetween(from float64, to float64) float64 {
+	paused := 0.0
+	start := -1.0
+	for _, e := range r.events {
+		if e.T > to {
+			break
+		}
+		switch e.Kind {
+		case "pause":
+			start = e.T
+		case "resume", "end":
+			if start >= 0 {
+				paused += math.Max(0, math.Min(e.T, to)-math.Max(start, from))
+			}
+			start = -1
+		}
+	}
+	if start >= 0 {
+		// still paused at to
+		paused += math.Max(0, to-math.Max(start, from))
+	}
+
+	return paused
+}
+
+// screenSide returns the side of the court that the seats on a screen play on.
+func (r *replay) screenSide(screen int) string {
+	for _, seat := range r.seats {
+		if seat.Screen == screen {
+			return seat.Side
+		}
+	}
+	return "LEFT"
+}
+
+// paddleAt works out where a seat's paddle is at t ms into the match.
+func (r *replay) paddleAt(seat int, t float64) *paddle {
+	s := r.seats[seat]
+	p := newPaddle(s.Side, s.Lane, s.Lanes, courtWidth, courtHeight)
+	from := 0.0
+
+	for _, e := range r.events {
+		if e.T > t {
+			break
+		}
+		if e.Kind == "paddle" && e.Seat == seat {
+			p.yPos = e.Y
+			p.yMovement = e.Move
+			from = e.T
+		}
+	}
+
+	for frames := int((t - from - r.pausedBetween(from, t)) / frameMillis); frames > 0 && p.yMovement != 0; frames-- {
+		p.move()
+	}
+
+	return p
+}
+
+func startReplay(doc dom.Document) {
+	r, err := newReplay(doc)
+	if err != nil {
+		console.Error(err.Error())
+		return
+	}
+	r.start()
+}
@@ -0,0 +1,158 @@
+// +build js
+
+package main
+
+import (
+	"strconv"
+
+	"github.com/gopherjs/gopherjs/js"
+	"honnef.co/go/js/dom"
+)
+
+// where the volume and mute settings are kept in the browser's local storage
+const (
+	volumeStorageKey = "pongish.volume"
+	mutedStorageKey  = "pongish.muted"
+	defaultVolume    = 0.5
+)
+
+// tone is a note of a sound effect.
+type tone struct {
+	freq   float64 // Hz
+	millis int
+	wave   string // an OscillatorNode type, sine, square, sawtooth or triangle
+}
+
+// soundEffects are the notes of each sound effect, played one after the other.
+var soundEffects = map[string][]tone{
+	"hit":   {{freq: 660, millis: 60, wave: "square"}},
+	"wall":  {{freq: 330, millis: 40, wave: "triangle"}},
+	"serve": {{freq: 440, millis: 80, wave: "sine"}, {freq: 880, millis: 80, wave: "sine"}},
+	"score": {{freq: 523, millis: 100, wave: "triangle"}, {freq: 784, millis: 160, wave: "triangle"}},
+	"turn": {{freq: 523, millis: 120, wave: "sine"}, {freq: 659, millis: 120, wave: "sine"},
+		{freq: 784, millis: 240, wave: "sine"}},
+	"end": {{freq: 392, millis: 160, wave: "sawtooth"}, {freq: 330, millis: 160, wave: "sawtooth"},
+		{freq: 262, millis: 320, wave: "sawtooth"}},
+}
+
+// sounds plays sound effects, synthesized with Web Audio. Browsers without it are silent.
+type sounds struct {
+	ctx    *js.Object // the AudioContext, nil if there isn't one
+	volume float64    // 0 to 1
+	muted  bool
+}
+
+// newSounds creates the sound player with the volume and mute settings saved in this browser.
+func newSounds() *sounds {
+	s := &sounds{volume: defaultVolume}
+
+	audioContext := js.Global.Get("AudioContext")
+	if audioContext == js.Undefined {
+		audioContext = js.Global.Get("webkitAudioContext")
+	}
+	if audioContext != js.Undefined {
+		s.ctx = audioContext.New()
+	}
+
+	if v, ok := loadSetting(volumeStorageKey); ok {
+		if volume, err := strconv.ParseFloat(v, 64); err == nil && volume >= 0 && volume <= 1 {
+			s.volume = volume
+		}
+	}
+	if v, ok := loadSetting(mutedStorageKey); ok {
+		s.muted = v == "true"
+	}
+
+	return s
+}
+
+// play plays the named sound effect.
+func (s *sounds) play(name string) {
+	if s == nil || s.ctx == nil || s.muted || s.volume == 0 {
+		return
+	}
+
+	// browsers don't start audio until the page has been interacted with
+	if s.ctx.Get("state").String() == "suspended" {
+		s.ctx.Call("resume")
+	}
+
+	at := s.ctx.Get("currentTime").Float()
+	for _, t := range soundEffects[name] {
+		end := at + float64(t.millis)/1000
+
+		osc := s.ctx.Call("createOscillator")
+		osc.Set("type", t.wave)
+		osc.Get("frequency").Call("setValueAtTime", t.freq, at)
+
+		// fade each note out so that it doesn't click
+		gain := s.ctx.Call("createGain")
+		gain.Get("gain").Call("setValueAtTime", s.volume*0.3, at)
+		gain.Get("gain").Call("exponentialRampToValueAtTime", 0.001, end)
+
+		osc.Call("connect", gain)
+		gain.Call("connect", s.ctx.Get("destination"))
+		osc.Call("start", at)
+		osc.Call("stop", end)
+
+		at = end
+	}
+}
+
+// setVolume sets the volume, 0 to 1, and saves it in this browser.
+func (s *sounds) setVolume(volume float64) {
+	s.volume = volume
+	saveSetting(volumeStorageKey, strconv.FormatFloat(volume, 'f', 2, 64))
+}
+
+// setMuted mutes or unmutes the sound effects and saves it in this browser.
+func (s *sounds) setMuted(muted bool) {
+	s.muted = muted
+	saveSetting(mutedStorageKey, strconv.FormatBool(muted))
+}
+
+// bindControls wires up the page's volume slider and mute checkbox, if it has them.
+func (s *sounds) bindControls(doc dom.Document) {
+	if el, ok := doc.GetElementByID("volume").(*dom.HTMLInputElement); ok {
+		el.Value = strconv.Itoa(int(s.volume * 100))
+		el.AddEventListener("input", false, func(event dom.Event) {
+			volume, err := strconv.Atoi(el.Value)
+			if err != nil {
+				return
+			}
+			s.setVolume(float64(volume) / 100)
+			s.play("wall")
+		})
+	}
+
+	if el, ok := doc.GetElementByID("mute").(*dom.HTMLInputElement); ok {
+		el.Checked = s.muted
+		el.AddEventListener("change", false, func(event dom.Event) {
+			s.setMuted(el.Checked)
+		})
+	}
+}
+
+// loadSetting returns a setting saved in the browser's local storage, false if it isn't there.
+func loadSetting(key string) (string, bool) {
+	storage := js.Global.Get("localStorage")
+	if storage == nil || storage == js.Undefined {
+		return "", false
+	}
+
+	v := storage.Call("getItem", key)
+	if v == nil || v == js.Undefined {
+		return "", false
+	}
+	return v.String(), true
+}
+
+// saveSetting saves a setting in the browser's local storage, it isn't saved if there's no local storage.
+func saveSetting(key string, value string) {
+	storage := js.Global.Get("localStorage")
+	if storage == nil || storage == js.Undefined {
+		return
+	}
+
+	storage.Call("setItem", key, value)
+}
@@ -0,0 +1,147 @@
+// +build js
+
+package main
+
+import (
+	"strings"
+
+	"honnef.co/go/js/dom"
+)
+
+const (
+	themeStorageKey = "pongish.theme"
+	defaultTheme    = "classic"
+)
+
+// theme is how the court looks. Nothing about it changes how the ball bounces, so the colours can be anything.
+// The names are listed in the server too, see theme.go in the server.
+type theme struct {
+	name        string
+	background  string // empty leaves the page showing through
+	ball        string
+	ballShape   string // circle or square
+	paddle      string
+	mate        string // teammates' paddles
+	paddleShape string // rect or pill
+	lines       string // the net, and the edges between screens in replays
+	text        string
+	obstacles   map[string]string // by kind, and goal for the end wall either side of a narrowed goal
+	powerUps    map[string]string // by kind
+	powerUpText string
+}
+
+// themes are the available themes by name.
+var themes = map[string]*theme{
+	"classic": {
+		name:        "classic",
+		ball:        "red",
+		ballShape:   "circle",
+		paddle:      "#0000ff",
+		mate:        "#0000ff",
+		paddleShape: "rect",
+		lines:       "#cccccc",
+		text:        "#888888",
+		obstacles:   map[string]string{"block": "#665500", "bumper": "#cc6600", "barrier": "#996600", "goal": "#665500"},
+		powerUps: map[string]string{"grow": "#00aa00", "shrink": "#aa5500", "fast": "#ff8800", "split": "#ddcc00",
+			"shield": "#00dd88"},
+		powerUpText: "#000000",
+	},
+	"night": {
+		name:        "night",
+		background:  "#101820",
+		ball:        "#f2f2f2",
+		ballShape:   "circle",
+		paddle:      "#4fc3f7",
+		mate:        "#81d4fa",
+		paddleShape: "pill",
+		lines:       "#37474f",
+		text:        "#90a4ae",
+		obstacles:   map[string]string{"block": "#455a64", "bumper": "#ff7043", "barrier": "#78909c", "goal": "#455a64"},
+		powerUps: map[string]string{"grow": "#66bb6a", "shrink": "#ab47bc", "fast": "#ffa726", "split": "#ffee58",
+			"shield": "#26c6da"},
+		powerUpText: "#101820",
+	},
+	"high-contrast": {
+		name:        "high-contrast",
+		background:  "#000000",
+		ball:        "#ffffff",
+		ballShape:   "square",
+		paddle:      "#ffff00",
+		mate:        "#ffff00",
+		paddleShape: "rect",
+		lines:       "#ffffff",
+		text:        "#ffffff",
+		obstacles:   map[string]string{"block": "#808080", "bumper": "#ff00ff", "barrier": "#00ffff", "goal": "#808080"},
+		powerUps: map[string]string{"grow": "#00ff00", "shrink": "#ff0000", "fast": "#ff8000", "split": "#ffffff",
+			"shield": "#00ffff"},
+		powerUpText: "#000000",
+	},
+	// the Okabe-Ito palette, which stays distinguishable with the common kinds of colour blindness
+	"colour-blind": {
+		name:        "colour-blind",
+		ball:        "#d55e00",
+		ballShape:   "circle",
+		paddle:      "#0072b2",
+		mate:        "#56b4e9",
+		paddleShape: "rect",
+		lines:       "#999999",
+		text:        "#666666",
+		obstacles:   map[string]string{"block": "#999999", "bumper": "#e69f00", "barrier": "#666666", "goal": "#999999"},
+		powerUps: map[string]string{"grow": "#009e73", "shrink": "#cc79a7", "fast": "#e69f00", "split": "#f0e442",
+			"shield": "#56b4e9"},
+		powerUpText: "#000000",
+	},
+}
+
+// findTheme returns the named theme, the default theme if there's no such theme.
+func findTheme(name string) *theme {
+	if th, ok := themes[strings.ToLower(name)]; ok {
+		return th
+	}
+	return themes[defaultTheme]
+}
+
+// chooseTheme returns the theme this browser's player chose, or the court's if they haven't chosen one.
+func chooseTheme(doc dom.Document) *theme {
+	if name, ok := loadSetting(themeStorageKey); ok && name != "" {
+		return findTheme(name)
+	}
+	if el := doc.GetElementByID("court-theme"); el != nil {
+		return findTheme(strings.TrimSpace(el.TextContent()))
+	}
+	return findTheme(defaultTheme)
+}
+
+// bindThemeControl wires up the page's theme picker, if it has one. The player's choice is saved in this
+// browser and passed to apply, choosing nothing goes back to the court's theme.
+func bindThemeControl(doc dom.Document, apply func(*theme)) {
+	sel, ok := doc.GetElementByID("theme").(*dom.HTMLSelectElement)
+	if !ok {
+		return
+	}
+
+	if name, ok := loadSetting(themeStorageKey); ok {
+		sel.Value = name
+	}
+	sel.AddEventListener("change", false, func(event dom.Event) {
+		saveSetting(themeStorageKey, sel.Value)
+		apply(chooseTheme(doc))
+	})
+}
+
+// clear paints the background of an area of the canvas.
+func (th *theme) clear(ctx *dom.CanvasRenderingContext2D, width int, height int) {
+	ctx.ClearRect(0, 0, width, height)
+	if th.background != "" {
+		ctx.FillStyle = th.background
+		ctx.FillRect(0, 0, width, height)
+	}
+}
+
+// powerUpColor returns the colour of a kind of power-up.
+func (th *theme) powerUpColor(kind string) string {
+	if color, ok := th.powerUps[kind]; ok {
+		return color
+	}
+	return th.text
+}
@@ -0,0 +1,80 @@
+// +build js
+
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/gopherjs/gopherjs/js"
+	"honnef.co/go/js/dom"
+)
+
+// canvasBorder is the width in CSS pixels of the border around the court, see app.css.
+const canvasBorder = 5
+
+// viewport fits a court measured in logical units, the units the server talks in, onto a canvas. The canvas is
+// made as large as the element around it allows while keeping the court's shape, the space left over either side
+// is the letterbox. The canvas has a pixel for every device pixel so that it stays sharp on high-DPI screens.
+type viewport struct {
+	el     *dom.HTMLCanvasElement
+	width  int     // of the court in logical units
+	height int     // of the court in logical units
+	scale  float64 // canvas pixels per logical unit
+}
+
+// newViewport fits a court width by height onto a canvas, and fits it again whenever the window changes size.
+func newViewport(el *dom.HTMLCanvasElement, width int, height int) *viewport {
+	v := &viewport{el: el, width: width, height: height}
+
+	win := dom.GetWindow()
+	win.AddEventListener("resize", false, func(event dom.Event) {
+		v.fit()
+	})
+	win.AddEventListener("orientationchange", false, func(event dom.Event) {
+		v.fit()
+	})
+	v.fit()
+
+	return v
+}
+
+// resize changes the size of the court.
+func (v *viewport) resize(width int, height int) {
+	v.width = width
+	v.height = height
+	v.fit()
+}
+
+// fit sizes the canvas to fit the court into the element around it.
+func (v *viewport) fit() {
+	area := v.el.ParentElement().GetBoundingClientRect()
+	border := float64(2 * canvasBorder)
+
+	cssScale := math.Min((area.Width-border)/float64(v.width), (area.Height-border)/float64(v.height))
+	if cssScale <= 0 {
+		// not laid out yet
+		cssScale = 1
+	}
+	cssWidth := math.Floor(float64(v.width) * cssScale)
+	cssHeight := math.Floor(float64(v.height) * cssScale)
+
+	style := v.el.Style()
+	style.SetProperty("width", fmt.Sprintf("%dpx", int(cssWidth+border)), "")
+	style.SetProperty("height", fmt.Sprintf("%dpx", int(cssHeight+border)), "")
+
+	ratio := 1.0
+	if dpr := js.Global.Get("devicePixelRatio"); dpr != js.Undefined && dpr.Float() > 0 {
+		ratio = dpr.Float()
+	}
+	v.el.Width = int(cssWidth * ratio)
+	v.el.Height = int(cssHeight * ratio)
+	v.scale = float64(v.el.Width) / float64(v.width)
+}
+
+// context returns the canvas's drawing context, set up to draw in logical units.
+func (v *viewport) context() *dom.CanvasRenderingContext2D {
+	ctx := v.el.GetContext2d()
+	ctx.Call("setTransform", v.scale, 0, 0, v.scale, 0, 0)
+	return ctx
+}